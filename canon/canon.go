@@ -0,0 +1,123 @@
+package canon
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var ErrAliasConflict = errors.New("alias conflict")
+
+// letters that are not a base letter plus diacritics, and so are not folded
+// by the decomposition
+var folds = map[rune]string{
+	'ø': "o", 'ß': "ss", 'æ': "ae", 'œ': "oe", 'đ': "d", 'ł': "l",
+	'♀': "-f", '♂': "-m",
+}
+
+// runes that PokéAPI drops from slugs, as in "mr. mime", "farfetch'd" or "type: null"
+var dropped = map[rune]bool{
+	'.': true, ':': true, '\'': true, '’': true, '‘': true, '`': true, '"': true,
+}
+
+// aliases for names that can't be derived from the display name with the
+// folding rules
+var defaultAliases = map[string]string{
+	"nidoran-female": "nidoran-f",
+	"nidoran-male":   "nidoran-m",
+	"nidoranf":       "nidoran-f",
+	"nidoranm":       "nidoran-m",
+	"hooh":           "ho-oh",
+	"porygonz":       "porygon-z",
+	"typenull":       "type-null",
+	"mrmime":         "mr-mime",
+	"mimejr":         "mime-jr",
+	"mrrime":         "mr-rime",
+}
+
+// Slugify turns a display name into PokéAPI's slug format: lowercase ascii
+// words separated by single hyphens. Diacritics are removed from latin letters
+// only, as in other scripts (e.g. the dakuten of kana) they make a different
+// letter.
+func Slugify(s string) string {
+	var b strings.Builder
+	latin := false
+	for _, r := range norm.NFD.String(strings.TrimSpace(s)) {
+		r = unicode.ToLower(r)
+		if unicode.Is(unicode.Mn, r) {
+			if !latin {
+				b.WriteRune(r)
+			}
+			continue
+		}
+		latin = unicode.Is(unicode.Latin, r)
+		switch {
+		case dropped[r]:
+		case folds[r] != "":
+			b.WriteString(folds[r])
+		case unicode.IsSpace(r) || r == '_' || r == '-':
+			b.WriteRune('-')
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	parts := strings.FieldsFunc(b.String(), func(r rune) bool { return r == '-' })
+	return norm.NFC.String(strings.Join(parts, "-"))
+}
+
+type Canonicalizer struct {
+	mu      sync.RWMutex
+	aliases map[string]string
+	// the slugs aliases resolve to, which can't be aliases themselves
+	slugs map[string]bool
+}
+
+func NewCanonicalizer() *Canonicalizer {
+	c := &Canonicalizer{aliases: make(map[string]string), slugs: make(map[string]bool)}
+	for alias, slug := range defaultAliases {
+		_ = c.AddAlias(alias, slug)
+	}
+	return c
+}
+
+// AddAlias makes every spelling that slugifies like alias resolve to slug,
+// aliases that already are the slug are not stored. Aliases already
+// resolving to another slug, or that are another pokemon's slug, are refused
+// with ErrAliasConflict: the first one added is kept.
+func (c *Canonicalizer) AddAlias(alias, slug string) error {
+	key := Slugify(alias)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slugs[slug] = true
+	// a slug always resolves to itself, even if it was an alias before
+	delete(c.aliases, slug)
+	if key == "" || key == slug {
+		return nil
+	}
+
+	if c.slugs[key] {
+		return fmt.Errorf("%w: %s is the slug of another pokemon than %s", ErrAliasConflict, key, slug)
+	}
+	if existing, found := c.aliases[key]; found && existing != slug {
+		return fmt.Errorf("%w: %s already resolves to %s, not %s", ErrAliasConflict, key, existing, slug)
+	}
+	c.aliases[key] = slug
+	return nil
+}
+
+func (c *Canonicalizer) Canonicalize(name string) string {
+	slug := Slugify(name)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if target, exists := c.aliases[slug]; exists {
+		return target
+	}
+	return slug
+}
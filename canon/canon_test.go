@@ -0,0 +1,69 @@
+package canon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlugify(t *testing.T) {
+	cases := map[string]string{
+		"Pikachu":             "pikachu",
+		"  pikachu \n":        "pikachu",
+		"Mr. Mime":            "mr-mime",
+		"mr mime":             "mr-mime",
+		"Mime Jr.":            "mime-jr",
+		"Farfetch'd":          "farfetchd",
+		"Sirfetch’d":          "sirfetchd",
+		"Nidoran♀":            "nidoran-f",
+		"Nidoran ♂":           "nidoran-m",
+		"Type: Null":          "type-null",
+		"Flabébé":             "flabebe",
+		"Porygon-Z":           "porygon-z",
+		"tapu_koko":           "tapu-koko",
+		"deoxys--attack":      "deoxys-attack",
+		"ピカチュウ":               "ピカチュウ",
+		"Flabe\u0301be\u0301": "flabebe",
+		"Ȟo-Ŏh":               "ho-oh",
+		"Łódź":                "lodz",
+		"ゼニガメ":                "ゼニガメ",
+	}
+	for input, expected := range cases {
+		assert.Equal(t, expected, Slugify(input), input)
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	c := NewCanonicalizer()
+	assert.Equal(t, "nidoran-f", c.Canonicalize("Nidoran Female"))
+	assert.Equal(t, "ho-oh", c.Canonicalize("HoOh"))
+	assert.Equal(t, "mr-mime", c.Canonicalize("Mr.Mime"))
+	assert.Equal(t, "ピカチュウ", c.Canonicalize("ピカチュウ"))
+
+	c.AddAlias("ピカチュウ", "pikachu")
+	c.AddAlias("Pikachu", "pikachu")
+	assert.Equal(t, "pikachu", c.Canonicalize("ピカチュウ"))
+	assert.Equal(t, "pikachu", c.Canonicalize("PIKACHU"))
+	assert.NotContains(t, c.aliases, "pikachu")
+}
+
+func TestAliasConflicts(t *testing.T) {
+	c := NewCanonicalizer()
+	assert.NoError(t, c.AddAlias("Bisasam", "bulbasaur"))
+	assert.NoError(t, c.AddAlias("Bisasam", "bulbasaur"), "adding the same alias again is fine")
+
+	// a name shared by two pokemon keeps resolving to the first one
+	assert.ErrorIs(t, c.AddAlias("bisasam", "ivysaur"), ErrAliasConflict)
+	assert.Equal(t, "bulbasaur", c.Canonicalize("Bisasam"))
+
+	// a localized name can't shadow another pokemon's slug
+	assert.NoError(t, c.AddAlias("Pikachu", "pikachu"))
+	assert.ErrorIs(t, c.AddAlias("Pikachu", "raichu"), ErrAliasConflict)
+	assert.Equal(t, "pikachu", c.Canonicalize("pikachu"))
+
+	// nor can an alias added before the pokemon was known
+	c = NewCanonicalizer()
+	assert.NoError(t, c.AddAlias("Raichu", "pikachu"))
+	assert.NoError(t, c.AddAlias("Raichu", "raichu"))
+	assert.Equal(t, "raichu", c.Canonicalize("raichu"))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sbaglivi/TL-Pokedex/pokemon"
+	"github.com/sbaglivi/TL-Pokedex/upstream"
+	"github.com/sbaglivi/TL-Pokedex/utils"
+)
+
+func main() {
+	out := flag.String("out", "pokemon/data/snapshot.json.gz", "where to write the gzipped snapshot")
+	baseURL := flag.String("base-url", "https://pokeapi.co/api/v2/", "PokéAPI base url")
+	limit := flag.Int("limit", 0, "number of species to include, 0 for all of them")
+	concurrency := flag.Int("concurrency", 8, "maximum number of concurrent requests to PokéAPI")
+	flag.Parse()
+
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: upstream.NewRetryTransport(http.DefaultTransport, upstream.DefaultRetryPolicy),
+	}
+	source, err := pokemon.NewHTTPSource(*baseURL, client)
+	if err != nil {
+		slog.Error("failed to initialize pokeapi source", "base-url", *baseURL, "error", err)
+		os.Exit(1)
+	}
+
+	snapshot, err := pokemon.FetchSnapshot(context.Background(), source, *limit, *concurrency)
+	if err != nil {
+		slog.Error("failed to fetch snapshot", "error", err)
+		os.Exit(1)
+	}
+
+	if err := utils.WriteFileAtomic(*out, snapshot.Write); err != nil {
+		slog.Error("failed to write snapshot", "path", *out, "error", err)
+		os.Exit(1)
+	}
+	slog.Info("snapshot written", "path", *out, "species", len(snapshot.Species), "varieties", len(snapshot.Varieties))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/sbaglivi/TL-Pokedex/translate"
+)
+
+// export or import the translations kept in a store, e.g. to seed a new
+// environment with those made elsewhere
+func main() {
+	storePath := flag.String("store", "translations.jsonl", "path of the translation store (TRANSLATION_STORE)")
+	file := flag.String("file", "-", "file to export to or import from, - for stdout/stdin")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] export|import\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || (flag.Arg(0) != "export" && flag.Arg(0) != "import") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	store, err := translate.OpenStore(*storePath)
+	if err != nil {
+		slog.Error("failed to open translation store", "error", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	if flag.Arg(0) == "export" {
+		err = export(store, *file)
+	} else {
+		err = importFile(store, *file)
+	}
+	if err != nil {
+		slog.Error("failed to "+flag.Arg(0)+" translations", "store", *storePath, "file", *file, "error", err)
+		store.Close()
+		os.Exit(1)
+	}
+}
+
+func export(store *translate.Store, path string) error {
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := store.Export(w); err != nil {
+		return err
+	}
+	slog.Info("translations exported", "records", store.Len())
+	return nil
+}
+
+func importFile(store *translate.Store, path string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	added, err := store.Import(r)
+	if err != nil {
+		return err
+	}
+	slog.Info("translations imported", "added", added, "records", store.Len())
+	return nil
+}
@@ -2,8 +2,12 @@ package handler
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"log/slog"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -13,20 +17,118 @@ import (
 
 type PokemonService interface {
 	GetPokemon(ctx context.Context, name string, translate bool) (*types.GetPokemonResult, error)
+	GetTranslatedPokemon(ctx context.Context, name string, style types.Translation) (*types.GetPokemonResult, error)
+	GetRandomPokemon(ctx context.Context, opts types.RandomOptions, translate bool) (*types.GetPokemonResult, error)
+	GetPopularPokemon(window string, limit int) (*types.GetPopularResult, error)
+	ComparePokemon(ctx context.Context, a, b string) (*types.Comparison, error)
+	GetPokemonDetails(ctx context.Context, name string) (*types.PokemonDetails, error)
+	GetTypeMatchup(ctx context.Context, attacker string, defenders []string) (*types.TypeMatchup, error)
+	AnalyzeTeam(ctx context.Context, names []string) (*types.TeamAnalysis, error)
+	Search(query string, limit int) (*types.SearchResponse, error)
+}
+
+// StatusProvider reports the health of an upstream service
+type StatusProvider interface {
+	Status() types.UpstreamStatus
+}
+
+// StyleLister lists the translation styles that can be requested
+type StyleLister interface {
+	Styles() []types.TranslationStyle
+}
+
+// RulesReloader reloads the rules that pick translation styles
+type RulesReloader interface {
+	Reload() (int, error)
+}
+
+// JobQueue translates pokemon descriptions in the background
+type JobQueue interface {
+	Submit(name string, style types.Translation, callbackURL string) (*types.TranslationJob, error)
+	Get(id string) (*types.TranslationJob, error)
+}
+
+// TextTranslator translates free text
+type TextTranslator interface {
+	TranslateText(ctx context.Context, text string, style types.Translation) (*types.TranslateTextResult, error)
 }
 
 type Handler struct {
-	pkmnSvc PokemonService
+	pkmnSvc   PokemonService
+	upstreams []StatusProvider
+	styles    StyleLister
+	rules     RulesReloader
+	jobs      JobQueue
+	text      TextTranslator
+	// bearer token required by admin endpoints, disabled when empty
+	adminToken string
+}
+
+type Option func(*Handler)
+
+func WithUpstreams(upstreams ...StatusProvider) Option {
+	return func(h *Handler) {
+		h.upstreams = append(h.upstreams, upstreams...)
+	}
+}
+
+func WithStyles(styles StyleLister) Option {
+	return func(h *Handler) {
+		h.styles = styles
+	}
 }
 
-func NewHandler(pkmnSvc PokemonService) *Handler {
-	return &Handler{pkmnSvc: pkmnSvc}
+func WithRules(rules RulesReloader) Option {
+	return func(h *Handler) {
+		h.rules = rules
+	}
+}
+
+// WithAdminToken enables admin endpoints, e.g. reloading the translation
+// rules, for requests with the header "Authorization: Bearer <token>"
+func WithAdminToken(token string) Option {
+	return func(h *Handler) {
+		h.adminToken = token
+	}
+}
+
+func WithJobs(jobs JobQueue) Option {
+	return func(h *Handler) {
+		h.jobs = jobs
+	}
+}
+
+func WithTextTranslator(text TextTranslator) Option {
+	return func(h *Handler) {
+		h.text = text
+	}
+}
+
+func NewHandler(pkmnSvc PokemonService, opts ...Option) *Handler {
+	h := Handler{pkmnSvc: pkmnSvc}
+	for _, opt := range opts {
+		opt(&h)
+	}
+	return &h
 }
 
 func (h *Handler) Register(app *fiber.App) {
 	v1 := app.Group("/api/v1")
+	v1.Get("/pokemon/random", timeout.NewWithContext(h.GetRandomPokemon, time.Second*9))
+	v1.Get("/pokemon/popular", h.GetPopularPokemon)
 	v1.Get("/pokemon/:name", timeout.NewWithContext(h.GetPokemon, time.Second*5))
+	v1.Get("/pokemon/:name/details", timeout.NewWithContext(h.GetPokemonDetails, time.Second*5))
 	v1.Get("/pokemon/translated/:name", timeout.NewWithContext(h.GetPokemonWithTranslation, time.Second*9))
+	v1.Post("/pokemon/:name/translations", h.CreateTranslationJob)
+	v1.Get("/jobs/:id", h.GetJob)
+	v1.Post("/translate", timeout.NewWithContext(h.TranslateText, time.Second*9))
+	v1.Get("/compare", timeout.NewWithContext(h.ComparePokemon, time.Second*5))
+	v1.Get("/types/matchup", timeout.NewWithContext(h.GetTypeMatchup, time.Second*5))
+	v1.Post("/teams/analyze", timeout.NewWithContext(h.AnalyzeTeam, time.Second*9))
+	v1.Get("/search", h.Search)
+	v1.Get("/status", h.GetStatus)
+	v1.Get("/translations", h.GetTranslationStyles)
+	v1.Post("/translations/rules/reload", h.ReloadTranslationRules)
 }
 
 func handleError(c *fiber.Ctx, err error, logMsg string) error {
@@ -35,8 +137,18 @@ func handleError(c *fiber.Ctx, err error, logMsg string) error {
 		return nil
 	case errors.Is(err, context.DeadlineExceeded):
 		return c.Status(fiber.StatusGatewayTimeout).JSON(types.Timeout.Wrap())
+	case errors.Is(err, types.ErrInvalidInput):
+		slog.Info(logMsg, "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(types.HTTPError(types.InputMessage(err)).Wrap())
 	case errors.Is(err, types.ErrNotFound):
 		return c.Status(404).JSON(types.NotFound.Wrap())
+	case errors.Is(err, types.ErrTooManyRequests):
+		var rateLimit *types.RateLimitError
+		if errors.As(err, &rateLimit) {
+			seconds := int(math.Ceil(time.Until(rateLimit.RetryAt).Seconds()))
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(seconds, 1)))
+		}
+		return c.Status(fiber.StatusTooManyRequests).JSON(types.TooManyRequests.Wrap())
 	default:
 		slog.Error(logMsg, "error", err)
 		return c.Status(500).JSON(types.InternalServerError.Wrap())
@@ -57,8 +169,9 @@ func (h *Handler) GetPokemon(c *fiber.Ctx) error {
 
 func (h *Handler) GetPokemonWithTranslation(c *fiber.Ctx) error {
 	name := c.Params("name")
+	style := types.Translation(strings.ToLower(strings.TrimSpace(c.Query("style"))))
 	ctx := c.UserContext()
-	pkmn, err := h.pkmnSvc.GetPokemon(ctx, name, true)
+	pkmn, err := h.pkmnSvc.GetTranslatedPokemon(ctx, name, style)
 
 	if err != nil {
 		return handleError(c, err, "failed to get pokemon")
@@ -66,3 +179,199 @@ func (h *Handler) GetPokemonWithTranslation(c *fiber.Ctx) error {
 
 	return c.Status(200).JSON(pkmn)
 }
+
+func (h *Handler) GetRandomPokemon(c *fiber.Ctx) error {
+	opts := types.RandomOptions{
+		Seed:       c.Query("seed"),
+		Habitat:    c.Query("habitat"),
+		Generation: c.Query("generation"),
+	}
+	ctx := c.UserContext()
+	pkmn, err := h.pkmnSvc.GetRandomPokemon(ctx, opts, c.QueryBool("translated"))
+
+	if err != nil {
+		return handleError(c, err, "failed to get random pokemon")
+	}
+
+	return c.Status(200).JSON(pkmn)
+}
+
+func (h *Handler) GetPopularPokemon(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 10)
+	if limit <= 0 || limit > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(types.HTTPError("limit must be between 1 and 100").Wrap())
+	}
+
+	popular, err := h.pkmnSvc.GetPopularPokemon(c.Query("window"), limit)
+	if err != nil {
+		return handleError(c, err, "failed to get popular pokemon")
+	}
+
+	return c.Status(200).JSON(popular)
+}
+
+func (h *Handler) ComparePokemon(c *fiber.Ctx) error {
+	a, b := c.Query("a"), c.Query("b")
+	if a == "" || b == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(types.HTTPError("query parameters a and b are required").Wrap())
+	}
+
+	ctx := c.UserContext()
+	comparison, err := h.pkmnSvc.ComparePokemon(ctx, a, b)
+	if err != nil {
+		return handleError(c, err, "failed to compare pokemon")
+	}
+
+	return c.Status(200).JSON(comparison)
+}
+
+func (h *Handler) GetPokemonDetails(c *fiber.Ctx) error {
+	name := c.Params("name")
+	ctx := c.UserContext()
+	details, err := h.pkmnSvc.GetPokemonDetails(ctx, name)
+
+	if err != nil {
+		return handleError(c, err, "failed to get pokemon details")
+	}
+
+	return c.Status(200).JSON(details)
+}
+
+func (h *Handler) GetTypeMatchup(c *fiber.Ctx) error {
+	attacker, defender := c.Query("attacker"), c.Query("defender")
+	if attacker == "" || defender == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(types.HTTPError("query parameters attacker and defender are required").Wrap())
+	}
+
+	ctx := c.UserContext()
+	matchup, err := h.pkmnSvc.GetTypeMatchup(ctx, attacker, strings.Split(defender, ","))
+	if err != nil {
+		return handleError(c, err, "failed to compute type matchup")
+	}
+
+	return c.Status(200).JSON(matchup)
+}
+
+func (h *Handler) AnalyzeTeam(c *fiber.Ctx) error {
+	var team types.TeamRequest
+	if err := c.BodyParser(&team); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(types.HTTPError("body must be a JSON object with a members list").Wrap())
+	}
+
+	ctx := c.UserContext()
+	analysis, err := h.pkmnSvc.AnalyzeTeam(ctx, team.Members)
+	if err != nil {
+		return handleError(c, err, "failed to analyze team")
+	}
+
+	return c.Status(200).JSON(analysis)
+}
+
+func (h *Handler) Search(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 10)
+	if limit <= 0 || limit > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(types.HTTPError("limit must be between 1 and 100").Wrap())
+	}
+
+	results, err := h.pkmnSvc.Search(c.Query("q"), limit)
+	if err != nil {
+		return handleError(c, err, "failed to search pokemon")
+	}
+
+	return c.Status(200).JSON(results)
+}
+
+func (h *Handler) GetStatus(c *fiber.Ctx) error {
+	status := types.StatusResponse{Upstreams: []types.UpstreamStatus{}}
+	for _, upstream := range h.upstreams {
+		status.Upstreams = append(status.Upstreams, upstream.Status())
+	}
+
+	return c.Status(200).JSON(status)
+}
+
+func (h *Handler) GetTranslationStyles(c *fiber.Ctx) error {
+	styles := types.TranslationStylesResponse{Styles: []types.TranslationStyle{}}
+	if h.styles != nil {
+		styles.Styles = h.styles.Styles()
+	}
+
+	return c.Status(200).JSON(styles)
+}
+
+// isAdmin reports whether the request carries the admin token
+func (h *Handler) isAdmin(c *fiber.Ctx) bool {
+	token, found := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) == 1
+}
+
+func (h *Handler) ReloadTranslationRules(c *fiber.Ctx) error {
+	if h.rules == nil || h.adminToken == "" {
+		return c.Status(404).JSON(types.NotFound.Wrap())
+	}
+	if !h.isAdmin(c) {
+		return c.Status(fiber.StatusUnauthorized).JSON(types.Unauthorized.Wrap())
+	}
+
+	n, err := h.rules.Reload()
+	if err != nil {
+		return handleError(c, err, "failed to reload translation rules")
+	}
+
+	return c.Status(200).JSON(types.ReloadRulesResult{Rules: n})
+}
+
+func (h *Handler) CreateTranslationJob(c *fiber.Ctx) error {
+	if h.jobs == nil {
+		return c.Status(404).JSON(types.NotFound.Wrap())
+	}
+
+	var req types.TranslationJobRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(types.HTTPError("body must be a JSON object with an optional style and callback_url").Wrap())
+		}
+	}
+	style := types.Translation(strings.ToLower(strings.TrimSpace(string(req.Style))))
+
+	job, err := h.jobs.Submit(c.Params("name"), style, strings.TrimSpace(req.CallbackURL))
+	if err != nil {
+		return handleError(c, err, "failed to create translation job")
+	}
+
+	c.Location("/api/v1/jobs/" + job.ID)
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+func (h *Handler) GetJob(c *fiber.Ctx) error {
+	if h.jobs == nil {
+		return c.Status(404).JSON(types.NotFound.Wrap())
+	}
+
+	job, err := h.jobs.Get(c.Params("id"))
+	if err != nil {
+		return handleError(c, err, "failed to get job")
+	}
+
+	return c.Status(200).JSON(job)
+}
+
+func (h *Handler) TranslateText(c *fiber.Ctx) error {
+	if h.text == nil {
+		return c.Status(404).JSON(types.NotFound.Wrap())
+	}
+
+	var req types.TranslateTextRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(types.HTTPError("body must be a JSON object with text and style").Wrap())
+	}
+	style := types.Translation(strings.ToLower(strings.TrimSpace(string(req.Style))))
+
+	ctx := c.UserContext()
+	result, err := h.text.TranslateText(ctx, req.Text, style)
+	if err != nil {
+		return handleError(c, err, "failed to translate text")
+	}
+
+	return c.Status(200).JSON(result)
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -24,6 +27,46 @@ func (m *mockPokemonService) GetPokemon(ctx context.Context, name string, transl
 	return args.Get(0).(*types.GetPokemonResult), args.Error(1)
 }
 
+func (m *mockPokemonService) GetTranslatedPokemon(ctx context.Context, name string, style types.Translation) (*types.GetPokemonResult, error) {
+	args := m.Called(ctx, name, style)
+	return args.Get(0).(*types.GetPokemonResult), args.Error(1)
+}
+
+func (m *mockPokemonService) GetRandomPokemon(ctx context.Context, opts types.RandomOptions, translated bool) (*types.GetPokemonResult, error) {
+	args := m.Called(ctx, opts, translated)
+	return args.Get(0).(*types.GetPokemonResult), args.Error(1)
+}
+
+func (m *mockPokemonService) GetPopularPokemon(window string, limit int) (*types.GetPopularResult, error) {
+	args := m.Called(window, limit)
+	return args.Get(0).(*types.GetPopularResult), args.Error(1)
+}
+
+func (m *mockPokemonService) ComparePokemon(ctx context.Context, a, b string) (*types.Comparison, error) {
+	args := m.Called(ctx, a, b)
+	return args.Get(0).(*types.Comparison), args.Error(1)
+}
+
+func (m *mockPokemonService) GetPokemonDetails(ctx context.Context, name string) (*types.PokemonDetails, error) {
+	args := m.Called(ctx, name)
+	return args.Get(0).(*types.PokemonDetails), args.Error(1)
+}
+
+func (m *mockPokemonService) GetTypeMatchup(ctx context.Context, attacker string, defenders []string) (*types.TypeMatchup, error) {
+	args := m.Called(ctx, attacker, defenders)
+	return args.Get(0).(*types.TypeMatchup), args.Error(1)
+}
+
+func (m *mockPokemonService) AnalyzeTeam(ctx context.Context, names []string) (*types.TeamAnalysis, error) {
+	args := m.Called(ctx, names)
+	return args.Get(0).(*types.TeamAnalysis), args.Error(1)
+}
+
+func (m *mockPokemonService) Search(query string, limit int) (*types.SearchResponse, error) {
+	args := m.Called(query, limit)
+	return args.Get(0).(*types.SearchResponse), args.Error(1)
+}
+
 func TestGetPokemon(t *testing.T) {
 	app := fiber.New()
 
@@ -64,6 +107,417 @@ func TestGetPokemonTranslationFail(t *testing.T) {
 	assert.Equal(t, 200, resp.StatusCode)
 }
 
+func TestGetTranslatedPokemon(t *testing.T) {
+	app := fiber.New()
+	mockSvc := new(mockPokemonService)
+	h := &Handler{pkmnSvc: mockSvc}
+	h.Register(app)
+
+	ruleBased := &types.GetPokemonResult{Pokemon: &types.Pokemon{Name: "onix", Desc: "Translated it is"}, Translation: types.Yoda}
+	pirate := &types.GetPokemonResult{Pokemon: &types.Pokemon{Name: "onix", Desc: "Arr"}, Translation: types.Pirate}
+	mockSvc.On("GetTranslatedPokemon", mock.Anything, "onix", types.Translation("")).Return(ruleBased, nil)
+	mockSvc.On("GetTranslatedPokemon", mock.Anything, "onix", types.Pirate).Return(pirate, nil)
+	mockSvc.On("GetTranslatedPokemon", mock.Anything, "onix", types.Translation("elvish")).Return(&types.GetPokemonResult{}, types.ErrInvalidInput)
+
+	for query, expected := range map[string]*types.GetPokemonResult{"": ruleBased, "?style=Pirate": pirate} {
+		req := httptest.NewRequest("GET", "/api/v1/pokemon/translated/onix"+query, nil)
+		resp, _ := app.Test(req, -1)
+		body, _ := io.ReadAll(resp.Body)
+		var got types.GetPokemonResult
+		json.Unmarshal(body, &got)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, *expected, got)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/pokemon/translated/onix?style=elvish", nil)
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestGetRandomPokemon(t *testing.T) {
+	app := fiber.New()
+	mockSvc := new(mockPokemonService)
+	h := &Handler{pkmnSvc: mockSvc}
+	h.Register(app)
+
+	expected := &types.GetPokemonResult{Pokemon: &types.Pokemon{Name: "zubat"}}
+	opts := types.RandomOptions{Seed: "2025-01-01", Habitat: "cave"}
+	mockSvc.On("GetRandomPokemon", mock.Anything, opts, true).Return(expected, nil)
+
+	req := httptest.NewRequest("GET", "/api/v1/pokemon/random?seed=2025-01-01&habitat=cave&translated=true", nil)
+	resp, _ := app.Test(req, -1)
+
+	body, _ := io.ReadAll(resp.Body)
+	var got types.GetPokemonResult
+	json.Unmarshal(body, &got)
+	assert.Equal(t, *expected, got)
+	assert.Equal(t, 200, resp.StatusCode)
+	mockSvc.AssertNotCalled(t, "GetPokemon", mock.Anything, "random", mock.Anything)
+}
+
+func TestGetPopularPokemon(t *testing.T) {
+	app := fiber.New()
+	mockSvc := new(mockPokemonService)
+	h := &Handler{pkmnSvc: mockSvc}
+	h.Register(app)
+
+	expected := &types.GetPopularResult{Window: "24h", Pokemon: []types.PopularPokemon{{Name: "pikachu", Count: 3}}}
+	mockSvc.On("GetPopularPokemon", "24h", 5).Return(expected, nil)
+	mockSvc.On("GetPopularPokemon", "forever", 10).Return(&types.GetPopularResult{}, types.InvalidInput("cannot parse window [forever]"))
+
+	req := httptest.NewRequest("GET", "/api/v1/pokemon/popular?window=24h&limit=5", nil)
+	resp, _ := app.Test(req, -1)
+	body, _ := io.ReadAll(resp.Body)
+	var got types.GetPopularResult
+	json.Unmarshal(body, &got)
+	assert.Equal(t, *expected, got)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	req = httptest.NewRequest("GET", "/api/v1/pokemon/popular?window=forever", nil)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, 400, resp.StatusCode)
+
+	req = httptest.NewRequest("GET", "/api/v1/pokemon/popular?limit=1000", nil)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestComparePokemon(t *testing.T) {
+	app := fiber.New()
+	mockSvc := new(mockPokemonService)
+	h := &Handler{pkmnSvc: mockSvc}
+	h.Register(app)
+
+	expected := &types.Comparison{Summary: types.ComparisonSummary{AWins: 1, TotalA: 90, TotalB: 60, Winner: "a"}}
+	mockSvc.On("ComparePokemon", mock.Anything, "raichu", "pikachu").Return(expected, nil)
+	mockSvc.On("ComparePokemon", mock.Anything, "raichu", "missing").Return(&types.Comparison{}, types.ErrNotFound)
+
+	req := httptest.NewRequest("GET", "/api/v1/compare?a=raichu&b=pikachu", nil)
+	resp, _ := app.Test(req, -1)
+	body, _ := io.ReadAll(resp.Body)
+	var got types.Comparison
+	json.Unmarshal(body, &got)
+	assert.Equal(t, *expected, got)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	req = httptest.NewRequest("GET", "/api/v1/compare?a=raichu&b=missing", nil)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, 404, resp.StatusCode)
+
+	req = httptest.NewRequest("GET", "/api/v1/compare?a=raichu", nil)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestGetTypeMatchup(t *testing.T) {
+	app := fiber.New()
+	mockSvc := new(mockPokemonService)
+	h := &Handler{pkmnSvc: mockSvc}
+	h.Register(app)
+
+	expected := &types.TypeMatchup{Attacker: "fire", Defender: []string{"grass", "steel"}, Multiplier: 4}
+	mockSvc.On("GetTypeMatchup", mock.Anything, "fire", []string{"grass", "steel"}).Return(expected, nil)
+
+	req := httptest.NewRequest("GET", "/api/v1/types/matchup?attacker=fire&defender=grass,steel", nil)
+	resp, _ := app.Test(req, -1)
+	body, _ := io.ReadAll(resp.Body)
+	var got types.TypeMatchup
+	json.Unmarshal(body, &got)
+	assert.Equal(t, *expected, got)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	req = httptest.NewRequest("GET", "/api/v1/types/matchup?attacker=fire", nil)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestGetPokemonDetails(t *testing.T) {
+	app := fiber.New()
+	mockSvc := new(mockPokemonService)
+	h := &Handler{pkmnSvc: mockSvc}
+	h.Register(app)
+
+	expected := &types.PokemonDetails{
+		Pokemon: &types.Pokemon{Name: "pikachu"},
+		Variety: &types.Variety{Name: "pikachu", Types: []string{"electric"}},
+		Defense: &types.TypeDefense{Weaknesses: map[string]float64{"ground": 2}},
+	}
+	mockSvc.On("GetPokemonDetails", mock.Anything, "pikachu").Return(expected, nil)
+
+	req := httptest.NewRequest("GET", "/api/v1/pokemon/pikachu/details", nil)
+	resp, _ := app.Test(req, -1)
+	body, _ := io.ReadAll(resp.Body)
+	var got types.PokemonDetails
+	json.Unmarshal(body, &got)
+	assert.Equal(t, *expected, got)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestAnalyzeTeam(t *testing.T) {
+	app := fiber.New()
+	mockSvc := new(mockPokemonService)
+	h := &Handler{pkmnSvc: mockSvc}
+	h.Register(app)
+
+	expected := &types.TeamAnalysis{UncoveredTypes: []string{"dragon"}, Stats: types.TeamStats{Total: 320}}
+	mockSvc.On("AnalyzeTeam", mock.Anything, []string{"pikachu", "onix"}).Return(expected, nil)
+
+	req := httptest.NewRequest("POST", "/api/v1/teams/analyze", strings.NewReader(`{"members":["pikachu","onix"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req, -1)
+	body, _ := io.ReadAll(resp.Body)
+	var got types.TeamAnalysis
+	json.Unmarshal(body, &got)
+	assert.Equal(t, *expected, got)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	req = httptest.NewRequest("POST", "/api/v1/teams/analyze", strings.NewReader(`{"members":`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestSearch(t *testing.T) {
+	app := fiber.New()
+	mockSvc := new(mockPokemonService)
+	h := &Handler{pkmnSvc: mockSvc}
+	h.Register(app)
+
+	expected := &types.SearchResponse{Query: "sleeps in caves", Results: []types.SearchResult{{Name: "zubat", Field: "description", Score: 1.5, Snippet: "It <em>sleeps</em> in <em>caves</em>"}}}
+	mockSvc.On("Search", "sleeps in caves", 10).Return(expected, nil)
+	mockSvc.On("Search", "", 10).Return(&types.SearchResponse{}, types.ErrInvalidInput)
+
+	req := httptest.NewRequest("GET", "/api/v1/search?q=sleeps+in+caves", nil)
+	resp, _ := app.Test(req, -1)
+	body, _ := io.ReadAll(resp.Body)
+	var got types.SearchResponse
+	json.Unmarshal(body, &got)
+	assert.Equal(t, *expected, got)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	req = httptest.NewRequest("GET", "/api/v1/search", nil)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+type staticStatus types.UpstreamStatus
+
+func (s staticStatus) Status() types.UpstreamStatus {
+	return types.UpstreamStatus(s)
+}
+
+func TestGetStatus(t *testing.T) {
+	app := fiber.New()
+	h := NewHandler(new(mockPokemonService), WithUpstreams(
+		staticStatus{Name: "pokeapi", State: "closed"},
+		staticStatus{Name: "funtranslations", State: "open", Failures: 5},
+	))
+	h.Register(app)
+
+	req := httptest.NewRequest("GET", "/api/v1/status", nil)
+	resp, _ := app.Test(req, -1)
+	body, _ := io.ReadAll(resp.Body)
+	var got types.StatusResponse
+	json.Unmarshal(body, &got)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, []types.UpstreamStatus{
+		{Name: "pokeapi", State: "closed"},
+		{Name: "funtranslations", State: "open", Failures: 5},
+	}, got.Upstreams)
+}
+
+type staticStyles []types.TranslationStyle
+
+func (s staticStyles) Styles() []types.TranslationStyle {
+	return s
+}
+
+func TestGetTranslationStyles(t *testing.T) {
+	app := fiber.New()
+	styles := staticStyles{
+		{Name: types.Yoda, Label: "Yoda", Available: true},
+		{Name: types.Klingon, Label: "Klingon", Available: false},
+	}
+	h := NewHandler(new(mockPokemonService), WithStyles(styles))
+	h.Register(app)
+
+	req := httptest.NewRequest("GET", "/api/v1/translations", nil)
+	resp, _ := app.Test(req, -1)
+	body, _ := io.ReadAll(resp.Body)
+	var got types.TranslationStylesResponse
+	json.Unmarshal(body, &got)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, []types.TranslationStyle(styles), got.Styles)
+}
+
+type fakeReloader struct {
+	rules int
+	err   error
+}
+
+func (r fakeReloader) Reload() (int, error) {
+	return r.rules, r.err
+}
+
+func TestReloadTranslationRules(t *testing.T) {
+	cases := []struct {
+		reloader      RulesReloader
+		adminToken    string
+		authorization string
+		status        int
+		body          string
+	}{
+		{nil, "t0ken", "Bearer t0ken", 404, `{"error":"not found"}`},
+		{fakeReloader{rules: 3}, "t0ken", "Bearer t0ken", 200, `{"rules":3}`},
+		{fakeReloader{err: fmt.Errorf("while loading /etc/rules.json: %w", types.InvalidInput("rule #1: unknown translation style [elvish]"))}, "t0ken", "Bearer t0ken", 400, `{"error":"rule #1: unknown translation style [elvish]"}`},
+		{fakeReloader{rules: 3}, "t0ken", "", 401, `{"error":"unauthorized"}`},
+		{fakeReloader{rules: 3}, "t0ken", "Bearer wrong", 401, `{"error":"unauthorized"}`},
+		{fakeReloader{rules: 3}, "t0ken", "t0ken", 401, `{"error":"unauthorized"}`},
+		// disabled without an admin token
+		{fakeReloader{rules: 3}, "", "Bearer ", 404, `{"error":"not found"}`},
+	}
+	for _, c := range cases {
+		app := fiber.New()
+		opts := []Option{WithAdminToken(c.adminToken)}
+		if c.reloader != nil {
+			opts = append(opts, WithRules(c.reloader))
+		}
+		NewHandler(new(mockPokemonService), opts...).Register(app)
+
+		req := httptest.NewRequest("POST", "/api/v1/translations/rules/reload", nil)
+		if c.authorization != "" {
+			req.Header.Set("Authorization", c.authorization)
+		}
+		resp, _ := app.Test(req, -1)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, c.status, resp.StatusCode)
+		assert.Equal(t, c.body, string(body))
+	}
+}
+
+// fakeJobs accepts jobs until it is full
+type fakeJobs struct {
+	jobs     map[string]*types.TranslationJob
+	capacity int
+}
+
+func (f *fakeJobs) Submit(name string, style types.Translation, callbackURL string) (*types.TranslationJob, error) {
+	if style == "elvish" {
+		return nil, types.InvalidInput("unknown translation style [elvish]")
+	}
+	if len(f.jobs) >= f.capacity {
+		return nil, fmt.Errorf("%w: the translation queue is full", types.ErrTooManyRequests)
+	}
+	job := types.TranslationJob{ID: fmt.Sprintf("job-%d", len(f.jobs)+1), Status: types.JobQueued, Pokemon: name, Style: style, CallbackURL: callbackURL}
+	f.jobs[job.ID] = &job
+	return &job, nil
+}
+
+func (f *fakeJobs) Get(id string) (*types.TranslationJob, error) {
+	job, found := f.jobs[id]
+	if !found {
+		return nil, fmt.Errorf("%w: job %s", types.ErrNotFound, id)
+	}
+	return job, nil
+}
+
+func TestTranslationJobs(t *testing.T) {
+	app := fiber.New()
+	jobs := &fakeJobs{jobs: map[string]*types.TranslationJob{}, capacity: 1}
+	h := NewHandler(new(mockPokemonService), WithJobs(jobs))
+	h.Register(app)
+
+	req := httptest.NewRequest("POST", "/api/v1/pokemon/pikachu/translations", strings.NewReader(`{"style": " Pirate ", "callback_url": "https://example.com/hooks/pokedex"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req, -1)
+	body, _ := io.ReadAll(resp.Body)
+	var created types.TranslationJob
+	json.Unmarshal(body, &created)
+	assert.Equal(t, 202, resp.StatusCode)
+	assert.Equal(t, "/api/v1/jobs/job-1", resp.Header.Get("Location"))
+	assert.Equal(t, types.TranslationJob{ID: "job-1", Status: types.JobQueued, Pokemon: "pikachu", Style: types.Pirate, CallbackURL: "https://example.com/hooks/pokedex"}, created)
+
+	jobs.jobs["job-1"].Status = types.JobDone
+	req = httptest.NewRequest("GET", "/api/v1/jobs/job-1", nil)
+	resp, _ = app.Test(req, -1)
+	body, _ = io.ReadAll(resp.Body)
+	var polled types.TranslationJob
+	json.Unmarshal(body, &polled)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, types.JobDone, polled.Status)
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"POST", "/api/v1/pokemon/pikachu/translations", "", 429},
+		{"POST", "/api/v1/pokemon/pikachu/translations", `{"style": "elvish"}`, 400},
+		{"POST", "/api/v1/pokemon/pikachu/translations", `{"style": `, 400},
+		{"GET", "/api/v1/jobs/job-2", "", 404},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, _ := app.Test(req, -1)
+		assert.Equal(t, c.status, resp.StatusCode, c.method+" "+c.path+" "+c.body)
+	}
+
+	app = fiber.New()
+	NewHandler(new(mockPokemonService)).Register(app)
+	resp, _ = app.Test(httptest.NewRequest("POST", "/api/v1/pokemon/pikachu/translations", nil), -1)
+	assert.Equal(t, 404, resp.StatusCode, "jobs are disabled without a queue")
+}
+
+type fakeTextTranslator struct {
+	err error
+}
+
+func (f fakeTextTranslator) TranslateText(ctx context.Context, text string, style types.Translation) (*types.TranslateTextResult, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &types.TranslateTextResult{Translated: strings.ToUpper(text), Style: style, Engine: "local"}, nil
+}
+
+func TestTranslateText(t *testing.T) {
+	rateLimited := &types.RateLimitError{RetryAt: time.Now().Add(90 * time.Second)}
+	cases := []struct {
+		translator TextTranslator
+		body       string
+		status     int
+		response   string
+	}{
+		{fakeTextTranslator{}, `{"text": "hello", "style": "Pirate"}`, 200, `{"translated":"HELLO","style":"pirate","engine":"local"}`},
+		{fakeTextTranslator{}, `{"text": `, 400, `{"error":"body must be a JSON object with text and style"}`},
+		{fakeTextTranslator{types.InvalidInput("text is required")}, `{"style": "pirate"}`, 400, `{"error":"text is required"}`},
+		{fakeTextTranslator{&types.ProviderError{Provider: "funtranslations", Err: types.InvalidInput("unknown translation style [elvish]")}}, `{"text": "hello", "style": "elvish"}`, 400, `{"error":"unknown translation style [elvish]"}`},
+		{fakeTextTranslator{fmt.Errorf("%w: style [elvish] rejected by api.example.com", types.ErrInvalidInput)}, `{"text": "hello", "style": "elvish"}`, 400, `{"error":"invalid input"}`},
+		{fakeTextTranslator{rateLimited}, `{"text": "hello", "style": "pirate"}`, 429, `{"error":"too many requests"}`},
+		{nil, `{"text": "hello", "style": "pirate"}`, 404, `{"error":"not found"}`},
+	}
+	for _, c := range cases {
+		app := fiber.New()
+		var opts []Option
+		if c.translator != nil {
+			opts = append(opts, WithTextTranslator(c.translator))
+		}
+		NewHandler(new(mockPokemonService), opts...).Register(app)
+
+		req := httptest.NewRequest("POST", "/api/v1/translate", strings.NewReader(c.body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, _ := app.Test(req, -1)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, c.status, resp.StatusCode, c.body)
+		assert.Equal(t, c.response, string(body), c.body)
+		if c.status == 429 {
+			retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			assert.InDelta(t, 90, retryAfter, 2)
+		}
+	}
+}
+
 func TestGetPokemon_NotFound(t *testing.T) {
 	app := fiber.New()
 	mockSvc := new(mockPokemonService)
@@ -95,7 +549,7 @@ func TestGetPokemon_InternalError(t *testing.T) {
 }
 
 type slowMockPokemonService struct {
-	mock.Mock
+	mockPokemonService
 }
 
 func (m *slowMockPokemonService) GetPokemon(ctx context.Context, name string, translated bool) (*types.GetPokemonResult, error) {
@@ -0,0 +1,280 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sbaglivi/TL-Pokedex/types"
+)
+
+const (
+	// jobs failing for reasons other than rate limits are tried this many times
+	maxAttempts    = 3
+	attemptTimeout = 30 * time.Second
+	// finished jobs can be polled for this long
+	retention = time.Hour
+	// jobs still waiting for the quota this long after being submitted fail,
+	// enough to wait for a daily quota to be refilled
+	maxWait = 24 * time.Hour
+)
+
+// Translator translates a pokemon's description, failing when it can't
+// instead of returning it untranslated
+type Translator interface {
+	TranslatePokemon(ctx context.Context, name string, style types.Translation) (*types.GetPokemonResult, error)
+}
+
+// Queue runs translation jobs on a pool of workers. Jobs that hit the
+// translation quota wait until it has room again, so they never spend more
+// than the quota allows. The Translator must fail rather than approximate
+// translations it can't make, as PokemonService.TranslatePokemon does.
+type Queue struct {
+	translator Translator
+	validStyle func(types.Translation) error
+	pending    chan string
+	capacity   int
+	now        func() time.Time
+	// first delay before retrying a failed job, doubled at every attempt
+	retryDelay time.Duration
+	// how long to wait when rate limited without being told until when
+	quotaWait time.Duration
+
+	notifier *Notifier
+
+	mu   sync.Mutex
+	jobs map[string]*types.TranslationJob
+	// jobs not finished yet, whether waiting for a worker or for a retry
+	unfinished int
+}
+
+type Option func(*Queue)
+
+// WithNotifier lets jobs register a callback URL, called by notifier once
+// they are finished
+func WithNotifier(notifier *Notifier) Option {
+	return func(q *Queue) {
+		q.notifier = notifier
+	}
+}
+
+// NewQueue creates a queue holding up to capacity unfinished jobs, counting
+// those waiting to be retried. Styles are checked with validStyle when the job
+// is submitted.
+func NewQueue(translator Translator, validStyle func(types.Translation) error, capacity int, opts ...Option) *Queue {
+	q := Queue{
+		translator: translator,
+		validStyle: validStyle,
+		pending:    make(chan string, capacity),
+		capacity:   capacity,
+		now:        time.Now,
+		retryDelay: 5 * time.Second,
+		quotaWait:  time.Minute,
+		jobs:       make(map[string]*types.TranslationJob),
+	}
+	for _, opt := range opts {
+		opt(&q)
+	}
+	return &q
+}
+
+// Run starts workers processing jobs until ctx is done
+func (q *Queue) Run(ctx context.Context, workers int) {
+	for range workers {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-q.pending:
+					q.process(ctx, id)
+				}
+			}
+		}()
+	}
+}
+
+func (q *Queue) Submit(name string, style types.Translation, callbackURL string) (*types.TranslationJob, error) {
+	if style != "" && q.validStyle != nil {
+		if err := q.validStyle(style); err != nil {
+			return nil, err
+		}
+	}
+	if callbackURL != "" {
+		if q.notifier == nil {
+			return nil, types.InvalidInput("callbacks are not enabled")
+		}
+		if err := checkCallbackURL(callbackURL); err != nil {
+			return nil, err
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sweep()
+
+	now := q.now()
+	job := types.TranslationJob{
+		ID:        uuid.NewString(),
+		Status:    types.JobQueued,
+		Pokemon:   name,
+		Style:     style,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if callbackURL != "" {
+		job.CallbackURL = callbackURL
+		job.CallbackStatus = CallbackPending
+	}
+	if q.unfinished >= q.capacity {
+		return nil, fmt.Errorf("%w: the translation queue is full", types.ErrTooManyRequests)
+	}
+	// can't block, as there are never more unfinished jobs than pending slots
+	q.pending <- job.ID
+	q.unfinished++
+	stored := job
+	q.jobs[job.ID] = &stored
+	return &job, nil
+}
+
+func (q *Queue) Get(id string) (*types.TranslationJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, found := q.jobs[id]
+	if !found {
+		return nil, fmt.Errorf("%w: job %s", types.ErrNotFound, id)
+	}
+	copied := *job
+	return &copied, nil
+}
+
+// sweep forgets finished jobs past their retention, q.mu must be held
+func (q *Queue) sweep() {
+	oldest := q.now().Add(-retention)
+	for id, job := range q.jobs {
+		finished := job.Status == types.JobDone || job.Status == types.JobFailed
+		if finished && job.UpdatedAt.Before(oldest) {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func (q *Queue) process(ctx context.Context, id string) {
+	q.mu.Lock()
+	job := q.jobs[id]
+	job.Status = types.JobRunning
+	job.Attempts++
+	job.RetryAt = nil
+	job.UpdatedAt = q.now()
+	name, style := job.Pokemon, job.Style
+	q.mu.Unlock()
+
+	attemptCtx, cancel := context.WithTimeout(ctx, attemptTimeout)
+	result, err := q.translator.TranslatePokemon(attemptCtx, name, style)
+	cancel()
+
+	retryAt, retry := q.finish(job, result, err)
+	switch {
+	case retry:
+		go q.requeue(ctx, id, retryAt)
+	case q.notifier != nil:
+		go q.callBack(ctx, job)
+	}
+}
+
+// callBack delivers the finished job to its callback URL, if it has one
+func (q *Queue) callBack(ctx context.Context, job *types.TranslationJob) {
+	q.mu.Lock()
+	finished := *job
+	q.mu.Unlock()
+	if finished.CallbackURL == "" {
+		return
+	}
+
+	status := CallbackDelivered
+	if err := q.notifier.Deliver(ctx, &finished); err != nil {
+		slog.Error("failed to deliver job callback", "job", finished.ID, "error", err)
+		status = CallbackFailed
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job.CallbackStatus = status
+}
+
+// finish records the outcome of an attempt, returning whether and when the
+// job should be tried again
+func (q *Queue) finish(job *types.TranslationJob, result *types.GetPokemonResult, err error) (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	job.UpdatedAt = now
+	if err == nil {
+		job.Status = types.JobDone
+		job.Result = result
+		q.unfinished--
+		return time.Time{}, false
+	}
+
+	var retryAt time.Time
+	var rateLimit *types.RateLimitError
+	switch {
+	case errors.As(err, &rateLimit) || errors.Is(err, types.ErrTooManyRequests):
+		// waiting for the quota doesn't count as an attempt, but is limited to
+		// maxWait in total
+		job.Attempts--
+		retryAt = now.Add(q.quotaWait)
+		if rateLimit != nil && rateLimit.RetryAt.After(now) {
+			retryAt = rateLimit.RetryAt
+		}
+		if retryAt.After(job.CreatedAt.Add(maxWait)) {
+			slog.Error("translation job gave up waiting for the quota", "job", job.ID, "pokemon", job.Pokemon, "retry_at", retryAt)
+			return q.fail(job, "translation quota exhausted")
+		}
+	case errors.Is(err, types.ErrInvalidInput):
+		slog.Info("translation job rejected", "job", job.ID, "pokemon", job.Pokemon, "error", err)
+		return q.fail(job, types.InputMessage(err))
+	case errors.Is(err, types.ErrNotFound):
+		return q.fail(job, string(types.NotFound))
+	case job.Attempts < maxAttempts:
+		slog.Warn("translation job attempt failed", "job", job.ID, "pokemon", job.Pokemon, "attempt", job.Attempts, "error", err)
+		retryAt = now.Add(q.retryDelay << (job.Attempts - 1))
+	default:
+		slog.Error("translation job failed", "job", job.ID, "pokemon", job.Pokemon, "error", err)
+		return q.fail(job, "translation failed")
+	}
+
+	job.Status = types.JobQueued
+	job.RetryAt = &retryAt
+	return retryAt, true
+}
+
+// fail marks the job as failed for good, q.mu must be held
+func (q *Queue) fail(job *types.TranslationJob, reason string) (time.Time, bool) {
+	job.Status = types.JobFailed
+	job.Error = reason
+	q.unfinished--
+	return time.Time{}, false
+}
+
+// requeue puts the job back in the queue at retryAt
+func (q *Queue) requeue(ctx context.Context, id string, retryAt time.Time) {
+	timer := time.NewTimer(retryAt.Sub(q.now()))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+	}
+	select {
+	case <-ctx.Done():
+	case q.pending <- id:
+	}
+}
@@ -0,0 +1,289 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+	"github.com/sbaglivi/TL-Pokedex/upstream"
+	"github.com/stretchr/testify/assert"
+)
+
+// scriptedTranslator fails with the errors in its script, in order, and then
+// succeeds
+type scriptedTranslator struct {
+	mu     sync.Mutex
+	script []error
+	calls  int
+}
+
+func (s *scriptedTranslator) TranslatePokemon(ctx context.Context, name string, style types.Translation) (*types.GetPokemonResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if len(s.script) > 0 {
+		err := s.script[0]
+		s.script = s.script[1:]
+		return nil, err
+	}
+	return &types.GetPokemonResult{Pokemon: &types.Pokemon{Name: name, Desc: "translated"}, Translation: style}, nil
+}
+
+func validStyle(style types.Translation) error {
+	if style != types.Yoda && style != types.Pirate {
+		return fmt.Errorf("%w: unknown translation style [%s]", types.ErrInvalidInput, style)
+	}
+	return nil
+}
+
+func waitFor(t *testing.T, q *Queue, id string, status types.JobStatus) *types.TranslationJob {
+	t.Helper()
+	var job *types.TranslationJob
+	assert.Eventually(t, func() bool {
+		job, _ = q.Get(id)
+		return job.Status == status
+	}, time.Second, time.Millisecond, "job %s never became %s", id, status)
+	return job
+}
+
+func TestQueueRunsJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	translator := &scriptedTranslator{}
+	q := NewQueue(translator, validStyle, 10)
+	q.Run(ctx, 2)
+
+	job, err := q.Submit("pikachu", types.Pirate, "")
+	assert.NoError(t, err)
+	assert.Equal(t, types.JobQueued, job.Status)
+
+	done := waitFor(t, q, job.ID, types.JobDone)
+	assert.Equal(t, "translated", done.Result.Pokemon.Desc)
+	assert.Equal(t, types.Pirate, done.Result.Translation)
+	assert.Equal(t, 1, done.Attempts)
+
+	_, err = q.Submit("pikachu", "elvish", "")
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+	_, err = q.Get("missing")
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+func TestQueueRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rateLimited := &types.RateLimitError{RetryAt: time.Now().Add(20 * time.Millisecond)}
+	outage := fmt.Errorf("%w: upstream is down", types.ErrGeneric)
+	translator := &scriptedTranslator{script: []error{rateLimited, rateLimited, outage}}
+	q := NewQueue(translator, validStyle, 10)
+	q.retryDelay = time.Millisecond
+	q.quotaWait = time.Millisecond
+	q.Run(ctx, 1)
+
+	job, err := q.Submit("pikachu", "", "")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		waiting, _ := q.Get(job.ID)
+		return waiting.Status == types.JobQueued && waiting.RetryAt != nil
+	}, time.Second, time.Millisecond, "rate limited jobs wait for the quota")
+	done := waitFor(t, q, job.ID, types.JobDone)
+	assert.Equal(t, 4, translator.calls)
+	assert.Equal(t, 2, done.Attempts, "waiting for the quota doesn't count as an attempt")
+	assert.Nil(t, done.RetryAt)
+
+	translator.script = []error{outage, outage, outage}
+	job, _ = q.Submit("pikachu", "", "")
+	failed := waitFor(t, q, job.ID, types.JobFailed)
+	assert.Equal(t, maxAttempts, failed.Attempts)
+	assert.Equal(t, "translation failed", failed.Error)
+
+	translator.script = []error{fmt.Errorf("%w: pokemon missingno", types.ErrNotFound)}
+	job, _ = q.Submit("missingno", "", "")
+	failed = waitFor(t, q, job.ID, types.JobFailed)
+	assert.Equal(t, 1, failed.Attempts, "missing pokemon are not retried")
+	assert.Equal(t, "not found", failed.Error)
+}
+
+func TestQueueCapacity(t *testing.T) {
+	q := NewQueue(&scriptedTranslator{}, validStyle, 1)
+	_, err := q.Submit("pikachu", "", "")
+	assert.NoError(t, err)
+	_, err = q.Submit("bulbasaur", "", "")
+	assert.ErrorIs(t, err, types.ErrTooManyRequests)
+
+	// jobs waiting to be retried still take up room
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rateLimited := &types.RateLimitError{RetryAt: time.Now().Add(time.Hour)}
+	q = NewQueue(&scriptedTranslator{script: []error{rateLimited}}, validStyle, 1)
+	q.Run(ctx, 1)
+	job, _ := q.Submit("pikachu", "", "")
+	assert.Eventually(t, func() bool {
+		waiting, _ := q.Get(job.ID)
+		return waiting.RetryAt != nil
+	}, time.Second, time.Millisecond)
+	_, err = q.Submit("bulbasaur", "", "")
+	assert.ErrorIs(t, err, types.ErrTooManyRequests)
+}
+
+func TestQueueWaitsForQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	now := time.Now()
+
+	// rate limits without a usable time to retry at wait for a default time
+	for _, retryAt := range []time.Time{{}, now.Add(-time.Minute)} {
+		q := NewQueue(&scriptedTranslator{script: []error{&types.RateLimitError{RetryAt: retryAt}}}, validStyle, 10)
+		q.now = func() time.Time { return now }
+		q.Run(ctx, 1)
+		job, _ := q.Submit("pikachu", "", "")
+		assert.Eventually(t, func() bool {
+			waiting, _ := q.Get(job.ID)
+			return waiting.RetryAt != nil && waiting.RetryAt.Equal(now.Add(q.quotaWait))
+		}, time.Second, time.Millisecond)
+	}
+
+	// jobs don't wait for the quota forever
+	q := NewQueue(&scriptedTranslator{script: []error{&types.RateLimitError{RetryAt: now.Add(maxWait + time.Hour)}}}, validStyle, 1)
+	q.Run(ctx, 1)
+	job, _ := q.Submit("pikachu", "", "")
+	failed := waitFor(t, q, job.ID, types.JobFailed)
+	assert.Equal(t, "translation quota exhausted", failed.Error)
+	_, err := q.Submit("bulbasaur", "", "")
+	assert.NoError(t, err, "failed jobs free their room")
+}
+
+func TestQueueForgetsFinishedJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	now := time.Now()
+	q := NewQueue(&scriptedTranslator{}, validStyle, 10)
+	q.now = func() time.Time { return now }
+	q.Run(ctx, 1)
+
+	job, _ := q.Submit("pikachu", "", "")
+	waitFor(t, q, job.ID, types.JobDone)
+
+	now = now.Add(retention + time.Minute)
+	_, _ = q.Submit("bulbasaur", "", "")
+	_, err := q.Get(job.ID)
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+func TestCallbackClient(t *testing.T) {
+	for address, refused := range map[string]bool{
+		"127.0.0.1:80":         true,
+		"[::1]:80":             true,
+		"[::ffff:10.0.0.1]:80": true,
+		"169.254.169.254:80":   true,
+		"192.168.1.10:443":     true,
+		"100.64.0.1:80":        true,
+		"0.0.0.0:80":           true,
+		"93.184.215.14:443":    false,
+		"[2606:4700::1]:443":   false,
+	} {
+		err := refuseInternal("tcp", address, nil)
+		if refused {
+			assert.ErrorIs(t, err, errForbiddenDestination, address)
+		} else {
+			assert.NoError(t, err, address)
+		}
+	}
+
+	// redirects are not followed, not even to public addresses
+	client := NewCallbackClient(time.Second)
+	assert.Equal(t, http.ErrUseLastResponse, client.CheckRedirect(nil, nil))
+}
+
+func TestCallbacks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var received []types.TranslationJob
+	var calls int
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get(TimestampHeader)
+		sentAt, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(sentAt, 0)) > time.Minute || r.Header.Get(SignatureHeader) != Sign([]byte("s3cret"), timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// the first delivery fails and must be retried
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var job types.TranslationJob
+		_ = json.Unmarshal(body, &job)
+		received = append(received, job)
+	}))
+	defer receiver.Close()
+
+	policy := upstream.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	translator := &scriptedTranslator{}
+	q := NewQueue(translator, validStyle, 10, WithNotifier(NewNotifier(receiver.Client(), "s3cret", policy)))
+	q.Run(ctx, 1)
+
+	job, err := q.Submit("pikachu", types.Yoda, receiver.URL+"/hooks")
+	assert.NoError(t, err)
+	assert.Equal(t, CallbackPending, job.CallbackStatus)
+	assert.Eventually(t, func() bool {
+		job, _ := q.Get(job.ID)
+		return job.CallbackStatus == CallbackDelivered
+	}, time.Second, time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, 2, calls)
+	assert.Len(t, received, 1)
+	assert.Equal(t, types.JobDone, received[0].Status)
+	assert.Equal(t, "translated", received[0].Result.Pokemon.Desc)
+	mu.Unlock()
+
+	// the signature doesn't hold for the same body sent at another time
+	assert.NotEqual(t, Sign([]byte("s3cret"), "1735725600", []byte("{}")), Sign([]byte("s3cret"), "1735725601", []byte("{}")))
+
+	// receivers rejecting the signature are not retried
+	q = NewQueue(translator, validStyle, 10, WithNotifier(NewNotifier(receiver.Client(), "wrong", policy)))
+	q.Run(ctx, 1)
+	job, _ = q.Submit("pikachu", types.Yoda, receiver.URL+"/hooks")
+	assert.Eventually(t, func() bool {
+		job, _ := q.Get(job.ID)
+		return job.CallbackStatus == CallbackFailed
+	}, time.Second, time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, 3, calls)
+	mu.Unlock()
+
+	// callbacks to internal addresses are refused, without retrying
+	q = NewQueue(translator, validStyle, 10, WithNotifier(NewNotifier(NewCallbackClient(time.Second), "s3cret", policy)))
+	q.Run(ctx, 1)
+	for _, callbackURL := range []string{receiver.URL + "/hooks", "http://169.254.169.254/latest/meta-data/"} {
+		job, err = q.Submit("pikachu", types.Yoda, callbackURL)
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			job, _ := q.Get(job.ID)
+			return job.CallbackStatus == CallbackFailed
+		}, time.Second, time.Millisecond, callbackURL)
+	}
+	mu.Lock()
+	assert.Equal(t, 3, calls)
+	mu.Unlock()
+
+	_, err = q.Submit("pikachu", types.Yoda, "ftp://example.com/hooks")
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+	_, err = NewQueue(translator, validStyle, 10).Submit("pikachu", types.Yoda, receiver.URL)
+	assert.ErrorIs(t, err, types.ErrInvalidInput, "callbacks need a notifier")
+}
@@ -0,0 +1,175 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+	"github.com/sbaglivi/TL-Pokedex/upstream"
+)
+
+// SignatureHeader holds "sha256=" followed by the hex encoded HMAC-SHA256 of
+// the timestamp, a dot and the request body, keyed with the webhook secret
+const SignatureHeader = "X-Pokedex-Signature"
+
+// TimestampHeader holds the unix time the callback was sent at, so that
+// receivers can reject old callbacks replayed by someone else
+const TimestampHeader = "X-Pokedex-Timestamp"
+
+const (
+	CallbackPending   = "pending"
+	CallbackDelivered = "delivered"
+	CallbackFailed    = "failed"
+)
+
+var DefaultDeliveryPolicy = upstream.RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Minute,
+}
+
+// Notifier POSTs finished jobs to their callback URL, signed so that
+// receivers can check they come from us
+type Notifier struct {
+	client *http.Client
+	secret []byte
+	policy upstream.RetryPolicy
+	now    func() time.Time
+}
+
+// errForbiddenDestination is returned for callbacks to addresses that aren't
+// publicly routable, which API callers must not be able to reach through us
+var errForbiddenDestination = errors.New("callback destination is not a public address")
+
+// NewCallbackClient returns a client fit for calling back URLs chosen by API
+// callers: it only connects to public addresses, checked when dialing so
+// that hostnames resolving to internal ones are refused too, and doesn't
+// follow redirects.
+func NewCallbackClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: refuseInternal}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// refuseInternal is a net.Dialer Control rejecting loopback, private,
+// link-local (including cloud metadata endpoints) and other non-public
+// addresses
+func refuseInternal(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errForbiddenDestination, address)
+	}
+	addr := addrPort.Addr().Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || sharedAddressSpace.Contains(addr) {
+		return fmt.Errorf("%w: %s", errForbiddenDestination, address)
+	}
+	return nil
+}
+
+// sharedAddressSpace is used for carrier-grade NAT and isn't publicly routable
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func NewNotifier(client *http.Client, secret string, policy upstream.RetryPolicy) *Notifier {
+	return &Notifier{client: client, secret: []byte(secret), policy: policy, now: time.Now}
+}
+
+// Sign returns the signature of a callback sent at timestamp with body
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkCallbackURL accepts only absolute http(s) URLs
+func checkCallbackURL(callbackURL string) error {
+	parsed, err := url.Parse(callbackURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return types.InvalidInput("callback_url must be an absolute http(s) URL")
+	}
+	return nil
+}
+
+// retryable reports whether a delivery that got status could succeed later
+func retryable(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout
+}
+
+// Deliver POSTs job to its callback URL, retrying with backoff on transport
+// errors, 5xx and 429 responses. Redirects and destinations refused by the
+// client are not retried.
+func (n *Notifier) Deliver(ctx context.Context, job *types.TranslationJob) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("%w while serializing job %s: %v", types.ErrGeneric, job.ID, err)
+	}
+	for attempt := 1; ; attempt++ {
+		status, err := n.post(ctx, job.CallbackURL, body)
+		if err == nil && status < 300 {
+			return nil
+		}
+		if errors.Is(err, errForbiddenDestination) {
+			return err
+		}
+		if err == nil {
+			err = fmt.Errorf("%w: callback responded with status %d", types.ErrGeneric, status)
+			if !retryable(status) {
+				return err
+			}
+		}
+		if attempt >= n.policy.MaxAttempts {
+			return err
+		}
+
+		slog.Info("retrying job callback", "job", job.ID, "attempt", attempt, "error", err)
+		timer := time.NewTimer(n.policy.Backoff(attempt - 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// post sends a single callback, signed with the current time
+func (n *Notifier) post(ctx context.Context, callbackURL string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("%w while preparing callback to %s: %v", types.ErrGeneric, callbackURL, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	timestamp := strconv.FormatInt(n.now().Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(n.secret, timestamp, body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%w while calling back %s: %w", types.ErrGeneric, callbackURL, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -1,34 +1,291 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/sbaglivi/TL-Pokedex/cache"
 	"github.com/sbaglivi/TL-Pokedex/handler"
+	"github.com/sbaglivi/TL-Pokedex/jobs"
 	"github.com/sbaglivi/TL-Pokedex/pokemon"
+	"github.com/sbaglivi/TL-Pokedex/popularity"
+	"github.com/sbaglivi/TL-Pokedex/rules"
 	"github.com/sbaglivi/TL-Pokedex/translate"
+	"github.com/sbaglivi/TL-Pokedex/types"
+	"github.com/sbaglivi/TL-Pokedex/upstream"
 	"github.com/sbaglivi/TL-Pokedex/utils"
 )
 
-func createPokemonService() (*pokemon.PokemonService, error) {
-	cache := cache.NewLRU(1024)
-	client := &http.Client{
-		Timeout: 4 * time.Second,
+func createPopularityTracker() (*popularity.Tracker, error) {
+	tracker := popularity.NewTracker()
+	path := os.Getenv("POPULARITY_FILE")
+	if path == "" {
+		return tracker, nil
+	}
+
+	if err := tracker.LoadFile(path); err != nil {
+		return nil, err
+	}
+	go tracker.PersistEvery(context.Background(), path, time.Minute)
+	return tracker, nil
+}
+
+func loadDataset() (*pokemon.Dataset, error) {
+	if path := os.Getenv("POKEDEX_DATASET"); path != "" {
+		return pokemon.LoadDatasetFile(path)
+	}
+	return pokemon.EmbeddedDataset()
+}
+
+// createSpeciesSource serves species from PokéAPI, falling back to the
+// dataset, or from the dataset alone in offline mode
+func createSpeciesSource(client *http.Client) (pokemon.SpeciesSource, error) {
+	dataset, err := loadDataset()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load species dataset: %w", err)
+	}
+	if os.Getenv("POKEDEX_OFFLINE") == "true" {
+		return dataset, nil
+	}
+
+	httpSource, err := pokemon.NewHTTPSource("https://pokeapi.co/api/v2/", client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize pokeapi source: %w", err)
+	}
+	return pokemon.NewFallbackSource(httpSource, dataset), nil
+}
+
+func getEnvInt(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %s [%s] as int: %w", name, value, err)
+	}
+	return n, nil
+}
+
+func getEnvPositiveInt(name string, defaultValue int) (int, error) {
+	n, err := getEnvInt(name, defaultValue)
+	if err != nil {
+		return 0, err
 	}
-	translateService, err := translate.NewTranslationService(cache, "https://api.funtranslations.com/translate/", client)
+	if n <= 0 {
+		return 0, fmt.Errorf("%s must be positive, got %d", name, n)
+	}
+	return n, nil
+}
 
+// createQuota matches Funtranslations' free tier by default, keeping a few
+// calls for the most popular pokemon
+func createQuota() (*translate.Quota, error) {
+	hourly, err := getEnvInt("TRANSLATION_HOURLY_LIMIT", 5)
+	if err != nil {
+		return nil, err
+	}
+	daily, err := getEnvInt("TRANSLATION_DAILY_LIMIT", 60)
+	if err != nil {
+		return nil, err
+	}
+
+	hourlyBudget := translate.Budget{Limit: hourly, Period: time.Hour, Reserved: hourly / 5}
+	if err := hourlyBudget.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid TRANSLATION_HOURLY_LIMIT: %w", err)
+	}
+	dailyBudget := translate.Budget{Limit: daily, Period: 24 * time.Hour, Reserved: daily / 5}
+	if err := dailyBudget.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid TRANSLATION_DAILY_LIMIT: %w", err)
+	}
+	return translate.NewQuota(hourlyBudget, dailyBudget), nil
+}
+
+// createBreakerConfig reads the configuration shared by the circuit breakers
+// of all upstreams, defaulting to upstream.DefaultBreakerConfig
+func createBreakerConfig() (upstream.BreakerConfig, error) {
+	config := upstream.DefaultBreakerConfig
+	threshold, err := getEnvPositiveInt("BREAKER_FAILURE_THRESHOLD", config.FailureThreshold)
+	if err != nil {
+		return config, err
+	}
+	openTimeoutMs, err := getEnvPositiveInt("BREAKER_OPEN_TIMEOUT_MS", int(config.OpenTimeout/time.Millisecond))
+	if err != nil {
+		return config, err
+	}
+	probes, err := getEnvPositiveInt("BREAKER_HALF_OPEN_REQUESTS", config.HalfOpenRequests)
+	if err != nil {
+		return config, err
+	}
+
+	config.FailureThreshold = threshold
+	config.OpenTimeout = time.Duration(openTimeoutMs) * time.Millisecond
+	config.HalfOpenRequests = probes
+	return config, nil
+}
+
+// createClient retries failed requests, while the breaker stops sending
+// them once the upstream keeps failing
+func createClient(breaker *upstream.Breaker, isFailure func(*http.Response, error) bool) *http.Client {
+	retrying := upstream.NewRetryTransport(http.DefaultTransport, upstream.DefaultRetryPolicy)
+	return &http.Client{
+		Timeout:   4 * time.Second,
+		Transport: upstream.NewBreakerTransport(retrying, breaker, isFailure),
+	}
+}
+
+// createStyleRegistry makes only the styles listed in TRANSLATION_STYLES
+// (comma separated) available, or all of them when it's not set
+func createStyleRegistry() (*translate.Registry, error) {
+	styles := translate.NewRegistry(translate.DefaultStyles...)
+	var names []string
+	for _, name := range strings.Split(os.Getenv("TRANSLATION_STYLES"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if err := styles.Restrict(names); err != nil {
+		return nil, fmt.Errorf("invalid TRANSLATION_STYLES: %w", err)
+	}
+	return styles, nil
+}
+
+// createTranslationStore opens the store in TRANSLATION_STORE, returning nil
+// when it's not set
+func createTranslationStore() (*translate.Store, error) {
+	path := os.Getenv("TRANSLATION_STORE")
+	if path == "" {
+		return nil, nil
+	}
+	return translate.OpenStore(path)
+}
+
+func createTranslationService(cache, textCache types.Cache, store *translate.Store, styles *translate.Registry, funtranslations *upstream.Breaker) (*translate.TranslationService, error) {
+	quota, err := createQuota()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure translation quota: %w", err)
+	}
+	secret, err := utils.LoadSecret("FUNTRANSLATIONS_API_SECRET")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load translation API secret: %w", err)
+	}
+	translationClient := createClient(funtranslations, upstream.RateLimitOrServerFailure)
+	translateService, err := translate.NewTranslationService(cache, "https://api.funtranslations.com/translate/", translationClient,
+		translate.WithQuota(quota),
+		translate.WithAPISecret(secret),
+		translate.WithStyles(styles),
+		translate.WithStore(store),
+		translate.WithTextCache(textCache),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize translation service: %w", err)
 	}
+	return translateService, nil
+}
 
-	pkmnService, err := pokemon.NewPokemonService(cache, translateService, "https://pokeapi.co/api/v2/pokemon-species/", client)
+// createTranslator tries Funtranslations first, then the self-hosted endpoint
+// at TRANSLATION_FALLBACK_URL if there is one, then the local translators.
+// The breaker of the self-hosted endpoint is nil when it's not configured.
+func createTranslator(cache, textCache types.Cache, store *translate.Store, styles *translate.Registry, funtranslations *translate.TranslationService, breakerConfig upstream.BreakerConfig) (*translate.Chain, *upstream.Breaker, error) {
+	timeoutMs, err := getEnvPositiveInt("TRANSLATION_TIMEOUT_MS", 3000)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize pokemon service: %w", err)
+		return nil, nil, err
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	providers := []translate.Provider{{Name: "funtranslations", Translator: funtranslations, Timeout: timeout}}
+
+	var breaker *upstream.Breaker
+	if baseURL := os.Getenv("TRANSLATION_FALLBACK_URL"); baseURL != "" {
+		breaker = upstream.NewBreaker("translations-fallback", breakerConfig)
+		client := createClient(breaker, upstream.RateLimitOrServerFailure)
+		selfHosted, err := translate.NewTranslationService(cache, baseURL, client, translate.WithStyles(styles), translate.WithStore(store), translate.WithTextCache(textCache))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to initialize fallback translation service: %w", err)
+		}
+		providers = append(providers, translate.Provider{Name: "self-hosted", Translator: selfHosted, Timeout: timeout})
+	}
+
+	if os.Getenv("LOCAL_TRANSLATIONS") != "false" {
+		providers = append(providers, translate.Provider{Name: "local", Translator: translate.NewLocal(), Approximate: true})
+	}
+	return translate.NewChain(providers...), breaker, nil
+}
+
+// styleValidator accepts only the styles available in the registry
+func styleValidator(styles *translate.Registry) func(types.Translation) error {
+	return func(style types.Translation) error {
+		_, err := styles.Lookup(style)
+		return err
+	}
+}
+
+// createJobQueue starts the workers translating descriptions in the
+// background. Jobs can only have callbacks when WEBHOOK_SECRET is set.
+func createJobQueue(pkmnService *pokemon.PokemonService, styles *translate.Registry) (*jobs.Queue, error) {
+	workers, err := getEnvPositiveInt("TRANSLATION_JOB_WORKERS", 2)
+	if err != nil {
+		return nil, err
+	}
+	capacity, err := getEnvPositiveInt("TRANSLATION_JOB_CAPACITY", 100)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []jobs.Option
+	secret, err := utils.LoadSecret("WEBHOOK_SECRET")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhook secret: %w", err)
+	}
+	if secret != "" {
+		client := jobs.NewCallbackClient(10 * time.Second)
+		opts = append(opts, jobs.WithNotifier(jobs.NewNotifier(client, string(secret), jobs.DefaultDeliveryPolicy)))
+	}
+
+	queue := jobs.NewQueue(pkmnService, styleValidator(styles), capacity, opts...)
+	queue.Run(context.Background(), workers)
+	return queue, nil
+}
+
+// createRulesEngine loads the rules picking translation styles from
+// TRANSLATION_RULES_FILE, or uses the built-in ones when it's not set
+func createRulesEngine(styles *translate.Registry) (*rules.Engine, error) {
+	engine, err := rules.NewEngine(os.Getenv("TRANSLATION_RULES_FILE"), styleValidator(styles))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load translation rules: %w", err)
+	}
+	return engine, nil
+}
+
+func createPokemonService(cache types.Cache, translator pokemon.Translator, engine *rules.Engine, pokeapi *upstream.Breaker) (*pokemon.PokemonService, error) {
+	tracker, err := createPopularityTracker()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize popularity tracker: %w", err)
+	}
+
+	source, err := createSpeciesSource(createClient(pokeapi, upstream.ServerFailure))
+	if err != nil {
+		return nil, err
+	}
+
+	pkmnService := pokemon.NewPokemonService(cache, translator, source,
+		pokemon.WithPopularityTracker(tracker),
+		pokemon.WithRules(engine),
+	)
+
+	if os.Getenv("SEARCH_CRAWL") == "true" {
+		go func() {
+			if err := pkmnService.IndexAllSpecies(context.Background(), 8); err != nil {
+				slog.Error("failed to index species descriptions", "error", err)
+			}
+		}()
 	}
 
 	return pkmnService, nil
@@ -38,14 +295,82 @@ func main() {
 	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
 	slog.SetDefault(logger)
 
-	pkmnService, err := createPokemonService()
+	breakerConfig, err := createBreakerConfig()
+	if err != nil {
+		slog.Error("during createBreakerConfig", "error", err)
+		os.Exit(1)
+	}
+	pokeapi := upstream.NewBreaker("pokeapi", breakerConfig)
+	funtranslations := upstream.NewBreaker("funtranslations", breakerConfig)
+	styles, err := createStyleRegistry()
+	if err != nil {
+		slog.Error("during createStyleRegistry", "error", err)
+		os.Exit(1)
+	}
+	engine, err := createRulesEngine(styles)
+	if err != nil {
+		slog.Error("during createRulesEngine", "error", err)
+		os.Exit(1)
+	}
+
+	// free text has its own cache, so that it can't evict pokemon data
+	textCache := cache.NewLRU(256)
+	cache := cache.NewLRU(1024)
+	store, err := createTranslationStore()
+	if err != nil {
+		slog.Error("during createTranslationStore", "error", err)
+		os.Exit(1)
+	}
+	translateService, err := createTranslationService(cache, textCache, store, styles, funtranslations)
+	if err != nil {
+		slog.Error("during createTranslationService", "error", err)
+		os.Exit(1)
+	}
+	translator, fallback, err := createTranslator(cache, textCache, store, styles, translateService, breakerConfig)
+	if err != nil {
+		slog.Error("during createTranslator", "error", err)
+		os.Exit(1)
+	}
+	pkmnService, err := createPokemonService(cache, translator, engine, pokeapi)
 	if err != nil {
 		slog.Error("during createPokemonService", "error", err)
 		os.Exit(1)
 	}
 
+	maxLength, err := getEnvInt("TRANSLATE_TEXT_MAX_LENGTH", 500)
+	if err == nil && maxLength <= 0 {
+		err = fmt.Errorf("TRANSLATE_TEXT_MAX_LENGTH must be positive, got %d", maxLength)
+	}
+	if err != nil {
+		slog.Error("failed to parse TRANSLATE_TEXT_MAX_LENGTH env var", "error", err)
+		os.Exit(1)
+	}
+	queue, err := createJobQueue(pkmnService, styles)
+	if err != nil {
+		slog.Error("during createJobQueue", "error", err)
+		os.Exit(1)
+	}
+
+	upstreams := []handler.StatusProvider{pokeapi, funtranslations}
+	if fallback != nil {
+		upstreams = append(upstreams, fallback)
+	}
+
+	adminToken, err := utils.LoadSecret("ADMIN_TOKEN")
+	if err != nil {
+		slog.Error("failed to load admin token", "error", err)
+		os.Exit(1)
+	}
+
 	app := fiber.New()
-	handler := handler.NewHandler(pkmnService)
+	handler := handler.NewHandler(pkmnService,
+		handler.WithAdminToken(string(adminToken)),
+		handler.WithUpstreams(upstreams...),
+		handler.WithStyles(translateService),
+		handler.WithRules(engine),
+		handler.WithJobs(queue),
+		handler.WithTextTranslator(translate.NewTextTranslator(translator, maxLength)),
+	)
 	handler.Register(app)
 	port, err := utils.GetPort()
 	if err != nil {
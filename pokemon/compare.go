@@ -0,0 +1,124 @@
+package pokemon
+
+import (
+	"context"
+	"slices"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+	"golang.org/x/sync/errgroup"
+)
+
+func difference[T comparable](a, b T) types.Difference[T] {
+	return types.Difference[T]{A: a, B: b, Same: a == b}
+}
+
+func winner(a, b int) string {
+	switch {
+	case a > b:
+		return "a"
+	case b > a:
+		return "b"
+	default:
+		return "tie"
+	}
+}
+
+func compareTypes(a, b []string) types.TypesComparison {
+	result := types.TypesComparison{Shared: []string{}, OnlyA: []string{}, OnlyB: []string{}}
+	for _, t := range a {
+		if slices.Contains(b, t) {
+			result.Shared = append(result.Shared, t)
+		} else {
+			result.OnlyA = append(result.OnlyA, t)
+		}
+	}
+	for _, t := range b {
+		if !slices.Contains(a, t) {
+			result.OnlyB = append(result.OnlyB, t)
+		}
+	}
+	return result
+}
+
+func baseStat(stats []types.Stat, name string) int {
+	for _, s := range stats {
+		if s.Name == name {
+			return s.Base
+		}
+	}
+	return 0
+}
+
+func compareStats(a, b []types.Stat) ([]types.StatComparison, types.ComparisonSummary) {
+	names := make([]string, 0, len(a))
+	for _, s := range a {
+		names = append(names, s.Name)
+	}
+	for _, s := range b {
+		if !slices.Contains(names, s.Name) {
+			names = append(names, s.Name)
+		}
+	}
+
+	var summary types.ComparisonSummary
+	stats := make([]types.StatComparison, 0, len(names))
+	for _, name := range names {
+		sc := types.StatComparison{Name: name, A: baseStat(a, name), B: baseStat(b, name)}
+		sc.Winner = winner(sc.A, sc.B)
+		switch sc.Winner {
+		case "a":
+			summary.AWins++
+		case "b":
+			summary.BWins++
+		default:
+			summary.Ties++
+		}
+		summary.TotalA += sc.A
+		summary.TotalB += sc.B
+		stats = append(stats, sc)
+	}
+	summary.Winner = winner(summary.TotalA, summary.TotalB)
+	return stats, summary
+}
+
+func compare(a, b *types.PokemonDetails) *types.Comparison {
+	stats, summary := compareStats(a.Variety.Stats, b.Variety.Stats)
+	return &types.Comparison{
+		A: a,
+		B: b,
+		Diff: types.ComparisonDiff{
+			Stats:     stats,
+			Types:     compareTypes(a.Variety.Types, b.Variety.Types),
+			Habitat:   difference(a.Pokemon.Habitat, b.Pokemon.Habitat),
+			Legendary: difference(a.Pokemon.IsLegendary, b.Pokemon.IsLegendary),
+			Height:    difference(a.Variety.Height, b.Variety.Height),
+			Weight:    difference(a.Variety.Weight, b.Variety.Weight),
+		},
+		Summary: summary,
+	}
+}
+
+func (ps *PokemonService) ComparePokemon(ctx context.Context, a, b string) (*types.Comparison, error) {
+	a, b = ps.names.Canonicalize(a), ps.names.Canonicalize(b)
+	if a == "" || b == "" {
+		return nil, types.InvalidInput("two pokemon names are required for a comparison")
+	}
+
+	var detailsA, detailsB *types.PokemonDetails
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		detailsA, err = ps.getDetails(gctx, a)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		detailsB, err = ps.getDetails(gctx, b)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return compare(detailsA, detailsB), nil
+}
@@ -0,0 +1,199 @@
+package pokemon
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+	"golang.org/x/sync/errgroup"
+)
+
+//go:embed data/snapshot.json.gz
+var embeddedSnapshot []byte
+
+// Snapshot is a copy of the PokéAPI resources used by the service, keyed by
+// name, so that it can work without reaching PokéAPI
+type Snapshot struct {
+	Species   map[string]APIPokemon `json:"species"`
+	Varieties map[string]APIVariety `json:"varieties"`
+	Types     map[string]APIType    `json:"types"`
+}
+
+// Dataset is a SpeciesSource backed by a Snapshot
+type Dataset struct {
+	snapshot Snapshot
+}
+
+func LoadDataset(r io.Reader) (*Dataset, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("while opening gzip dataset: %w", err)
+	}
+	defer gz.Close()
+
+	var snapshot Snapshot
+	if err := json.NewDecoder(gz).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("while decoding dataset: %w", err)
+	}
+	return &Dataset{snapshot: snapshot}, nil
+}
+
+func LoadDatasetFile(path string) (*Dataset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("while opening dataset %s: %w", path, err)
+	}
+	defer f.Close()
+
+	return LoadDataset(f)
+}
+
+func EmbeddedDataset() (*Dataset, error) {
+	return LoadDataset(bytes.NewReader(embeddedSnapshot))
+}
+
+func (s *Snapshot) Write(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(s); err != nil {
+		gz.Close()
+		return fmt.Errorf("while encoding dataset: %w", err)
+	}
+	return gz.Close()
+}
+
+func (d *Dataset) Len() int {
+	return len(d.snapshot.Species)
+}
+
+func (d *Dataset) speciesWhere(matches func(*APIPokemon) bool) []string {
+	var result []string
+	for name, species := range d.snapshot.Species {
+		if matches(&species) {
+			result = append(result, name)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+func lookup[T any](resources map[string]T, kind, name string) (*T, error) {
+	resource, exists := resources[name]
+	if !exists {
+		return nil, fmt.Errorf("%w in dataset while searching for %s %s", types.ErrNotFound, kind, name)
+	}
+	return &resource, nil
+}
+
+func (d *Dataset) Species(ctx context.Context, name string) (*APIPokemon, error) {
+	return lookup(d.snapshot.Species, "species", name)
+}
+
+func (d *Dataset) Variety(ctx context.Context, name string) (*APIVariety, error) {
+	return lookup(d.snapshot.Varieties, "variety", name)
+}
+
+func (d *Dataset) Type(ctx context.Context, name string) (*APIType, error) {
+	return lookup(d.snapshot.Types, "type", name)
+}
+
+func (d *Dataset) SpeciesNames(ctx context.Context) ([]string, error) {
+	return d.speciesWhere(func(*APIPokemon) bool { return true }), nil
+}
+
+func (d *Dataset) HabitatSpecies(ctx context.Context, habitat string) ([]string, error) {
+	names := d.speciesWhere(func(p *APIPokemon) bool { return p.APIHabitat.Name == habitat })
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w in dataset while searching for habitat %s", types.ErrNotFound, habitat)
+	}
+	return names, nil
+}
+
+func (d *Dataset) GenerationSpecies(ctx context.Context, generation string) ([]string, error) {
+	names := d.speciesWhere(func(p *APIPokemon) bool { return p.Generation.Name == generation })
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w in dataset while searching for generation %s", types.ErrNotFound, generation)
+	}
+	return names, nil
+}
+
+// FetchSnapshot copies species (all of them when limit is 0), their
+// varieties and the type data from source
+func FetchSnapshot(ctx context.Context, source SpeciesSource, limit, concurrency int) (*Snapshot, error) {
+	names, err := source.SpeciesNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(names) > limit {
+		names = names[:limit]
+	}
+
+	species := make([]*APIPokemon, len(names))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for i, name := range names {
+		g.Go(func() error {
+			var err error
+			species[i], err = source.Species(gctx, name)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var varietyNames []string
+	for _, s := range species {
+		for _, v := range s.Varieties {
+			varietyNames = append(varietyNames, v.Pokemon.Name)
+		}
+	}
+	varieties := make([]*APIVariety, len(varietyNames))
+	apiTypes := make([]*APIType, len(BattleTypes))
+	g, gctx = errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for i, name := range varietyNames {
+		g.Go(func() error {
+			var err error
+			varieties[i], err = source.Variety(gctx, name)
+			return err
+		})
+	}
+	for i, name := range BattleTypes {
+		g.Go(func() error {
+			var err error
+			apiTypes[i], err = source.Type(gctx, name)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	snapshot := Snapshot{
+		Species:   make(map[string]APIPokemon, len(species)),
+		Varieties: make(map[string]APIVariety, len(varieties)),
+		Types:     make(map[string]APIType, len(apiTypes)),
+	}
+	for _, s := range species {
+		// only the entry picked by getDescription is kept
+		i := max(slices.IndexFunc(s.FlavorTextEntries, func(e FlavorTextEntry) bool { return e.Language.Name == "en" }), 0)
+		if len(s.FlavorTextEntries) > 0 {
+			s.FlavorTextEntries = s.FlavorTextEntries[i : i+1]
+		}
+		snapshot.Species[s.Name] = *s
+	}
+	for _, v := range varieties {
+		snapshot.Varieties[v.Name] = *v
+	}
+	for _, t := range apiTypes {
+		snapshot.Types[t.Name] = *t
+	}
+	return &snapshot, nil
+}
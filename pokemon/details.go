@@ -0,0 +1,84 @@
+package pokemon
+
+import (
+	"cmp"
+	"context"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+)
+
+type APIStat struct {
+	BaseStat int        `json:"base_stat"`
+	Stat     NameAndURL `json:"stat"`
+}
+
+type APIPokemonType struct {
+	Slot int        `json:"slot"`
+	Type NameAndURL `json:"type"`
+}
+
+type APIVariety struct {
+	Name    string           `json:"name"`
+	Species NameAndURL       `json:"species"`
+	Height  int              `json:"height"`
+	Weight  int              `json:"weight"`
+	Stats   []APIStat        `json:"stats"`
+	Types   []APIPokemonType `json:"types"`
+}
+
+func (v *APIVariety) toInternal() types.Variety {
+	variety := types.Variety{
+		Name:    v.Name,
+		Species: v.Species.Name,
+		Height:  v.Height,
+		Weight:  v.Weight,
+		Types:   make([]string, 0, len(v.Types)),
+		Stats:   make([]types.Stat, 0, len(v.Stats)),
+	}
+	for _, t := range v.Types {
+		variety.Types = append(variety.Types, t.Type.Name)
+	}
+	for _, s := range v.Stats {
+		variety.Stats = append(variety.Stats, types.Stat{Name: s.Stat.Name, Base: s.BaseStat})
+	}
+	return variety
+}
+
+func (ps *PokemonService) getVarietyFromSource(ctx context.Context, name string) (*types.Variety, error) {
+	apiVariety, err := ps.source.Variety(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	internal := apiVariety.toInternal()
+	return &internal, nil
+}
+
+func (ps *PokemonService) getVariety(ctx context.Context, name string) (*types.Variety, error) {
+	return getCached(ctx, ps, "variety:"+name, func(ctx context.Context) (*types.Variety, error) {
+		return ps.getVarietyFromSource(ctx, name)
+	})
+}
+
+func (ps *PokemonService) getDetails(ctx context.Context, name string) (*types.PokemonDetails, error) {
+	pkmn, variety, err := ps.resolvePokemon(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if variety == nil {
+		variety, err = ps.getVariety(ctx, cmp.Or(pkmn.DefaultVariety, name))
+		if err != nil {
+			return nil, err
+		}
+	}
+	defense, err := ps.getTypeDefense(ctx, variety.Types)
+	if err != nil {
+		return nil, err
+	}
+	return &types.PokemonDetails{Pokemon: pkmn, Variety: variety, Defense: defense}, nil
+}
+
+func (ps *PokemonService) GetPokemonDetails(ctx context.Context, name string) (*types.PokemonDetails, error) {
+	return ps.getDetails(ctx, ps.names.Canonicalize(name))
+}
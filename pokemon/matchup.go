@@ -0,0 +1,129 @@
+package pokemon
+
+import (
+	"context"
+	"slices"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+)
+
+// the types that take part in damage calculations, PokéAPI also lists
+// "unknown", "shadow" and "stellar" which have no damage relations
+var BattleTypes = []string{
+	"normal", "fire", "water", "electric", "grass", "ice",
+	"fighting", "poison", "ground", "flying", "psychic", "bug",
+	"rock", "ghost", "dragon", "dark", "steel", "fairy",
+}
+
+type DamageRelations struct {
+	DoubleDamageFrom []NameAndURL `json:"double_damage_from"`
+	HalfDamageFrom   []NameAndURL `json:"half_damage_from"`
+	NoDamageFrom     []NameAndURL `json:"no_damage_from"`
+}
+
+type APIType struct {
+	Name            string          `json:"name"`
+	DamageRelations DamageRelations `json:"damage_relations"`
+}
+
+// toMultipliers maps each attacking type to the damage multiplier it gets
+// against this type, types that deal normal damage are omitted
+func (t *APIType) toMultipliers() map[string]float64 {
+	multipliers := make(map[string]float64)
+	for _, r := range t.DamageRelations.DoubleDamageFrom {
+		multipliers[r.Name] = 2
+	}
+	for _, r := range t.DamageRelations.HalfDamageFrom {
+		multipliers[r.Name] = 0.5
+	}
+	for _, r := range t.DamageRelations.NoDamageFrom {
+		multipliers[r.Name] = 0
+	}
+	return multipliers
+}
+
+func (ps *PokemonService) getTypeFromSource(ctx context.Context, name string) (map[string]float64, error) {
+	apiType, err := ps.source.Type(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return apiType.toMultipliers(), nil
+}
+
+func (ps *PokemonService) getDefenseMultipliers(ctx context.Context, name string) (map[string]float64, error) {
+	return getCached(ctx, ps, "type:"+name, func(ctx context.Context) (map[string]float64, error) {
+		return ps.getTypeFromSource(ctx, name)
+	})
+}
+
+func validateType(name string) error {
+	if !slices.Contains(BattleTypes, name) {
+		return types.InvalidInput("unknown type [%s]", name)
+	}
+	return nil
+}
+
+func (ps *PokemonService) multiplier(ctx context.Context, attacker string, defenders []string) (float64, error) {
+	result := 1.0
+	for _, defender := range defenders {
+		multipliers, err := ps.getDefenseMultipliers(ctx, defender)
+		if err != nil {
+			return 0, err
+		}
+		if m, exists := multipliers[attacker]; exists {
+			result *= m
+		}
+	}
+	return result, nil
+}
+
+func (ps *PokemonService) getTypeDefense(ctx context.Context, defenders []string) (*types.TypeDefense, error) {
+	defense := types.TypeDefense{
+		Weaknesses:  make(map[string]float64),
+		Resistances: make(map[string]float64),
+		Immunities:  []string{},
+	}
+	for _, attacker := range BattleTypes {
+		m, err := ps.multiplier(ctx, attacker, defenders)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case m == 0:
+			defense.Immunities = append(defense.Immunities, attacker)
+		case m < 1:
+			defense.Resistances[attacker] = m
+		case m > 1:
+			defense.Weaknesses[attacker] = m
+		}
+	}
+	return &defense, nil
+}
+
+func (ps *PokemonService) GetTypeMatchup(ctx context.Context, attacker string, defenders []string) (*types.TypeMatchup, error) {
+	attacker = normalize(attacker)
+	if err := validateType(attacker); err != nil {
+		return nil, err
+	}
+	if len(defenders) == 0 || len(defenders) > 2 {
+		return nil, types.InvalidInput("expected one or two defending types, got %d", len(defenders))
+	}
+
+	normalized := make([]string, 0, len(defenders))
+	for _, d := range defenders {
+		d = normalize(d)
+		if err := validateType(d); err != nil {
+			return nil, err
+		}
+		if slices.Contains(normalized, d) {
+			return nil, types.InvalidInput("defending type [%s] is repeated", d)
+		}
+		normalized = append(normalized, d)
+	}
+
+	m, err := ps.multiplier(ctx, attacker, normalized)
+	if err != nil {
+		return nil, err
+	}
+	return &types.TypeMatchup{Attacker: attacker, Defender: normalized, Multiplier: m}, nil
+}
@@ -1,21 +1,31 @@
 package pokemon
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
-	"net/http"
-	"net/url"
-	"strings"
+	"net"
+	"time"
 
+	"github.com/sbaglivi/TL-Pokedex/canon"
+	"github.com/sbaglivi/TL-Pokedex/popularity"
+	"github.com/sbaglivi/TL-Pokedex/rules"
+	"github.com/sbaglivi/TL-Pokedex/search"
 	"github.com/sbaglivi/TL-Pokedex/types"
+	"github.com/sbaglivi/TL-Pokedex/upstream"
 	"github.com/sbaglivi/TL-Pokedex/utils"
 	"golang.org/x/sync/singleflight"
 )
 
+// the most searched pokemon in the last day get translations even when the
+// quota is almost exhausted
+const (
+	priorityWindow = 24 * time.Hour
+	priorityRank   = 20
+)
+
 type NameAndURL struct {
 	Name string `json:"name"`
 }
@@ -25,44 +35,93 @@ type FlavorTextEntry struct {
 	Language   NameAndURL `json:"language"`
 }
 
+type SpeciesVariety struct {
+	IsDefault bool       `json:"is_default"`
+	Pokemon   NameAndURL `json:"pokemon"`
+}
+
+type LocalizedName struct {
+	Name     string     `json:"name"`
+	Language NameAndURL `json:"language"`
+}
+
 type APIPokemon struct {
 	IsLegendary       bool              `json:"is_legendary"`
+	IsMythical        bool              `json:"is_mythical"`
 	Name              string            `json:"name"`
 	APIHabitat        NameAndURL        `json:"habitat"`
+	Generation        NameAndURL        `json:"generation"`
 	FlavorTextEntries []FlavorTextEntry `json:"flavor_text_entries"`
+	Varieties         []SpeciesVariety  `json:"varieties"`
+	Names             []LocalizedName   `json:"names"`
 }
 
 type Translator interface {
 	Translate(context.Context, string, string, types.Translation) (*string, error)
 }
 
+// providerTranslator is implemented by translators that try several
+// providers, e.g. translate.Chain, and report which one translated the text
+type providerTranslator interface {
+	TranslateWithProvider(context.Context, string, string, types.Translation) (*string, string, error)
+}
+
 type PokemonService struct {
-	cache                 types.Cache
-	translator            Translator
-	baseURL               *url.URL
-	client                *http.Client
-	group                 singleflight.Group
-	getPokemonFromAPIfunc func(context.Context, string) (*types.Pokemon, error)
+	cache      types.Cache
+	translator Translator
+	source     SpeciesSource
+	group      singleflight.Group
+	popularity *popularity.Tracker
+	names      *canon.Canonicalizer
+	search     *search.Index
+	styleRules *rules.Engine
 }
 
-func NewPokemonService(cache types.Cache, translator Translator, baseURL string, client *http.Client) (*PokemonService, error) {
-	parsed, err := url.Parse(baseURL)
-	if err != nil {
-		return nil, err
+type Option func(*PokemonService)
+
+func WithPopularityTracker(tracker *popularity.Tracker) Option {
+	return func(ps *PokemonService) {
+		ps.popularity = tracker
 	}
+}
 
+func WithCanonicalizer(names *canon.Canonicalizer) Option {
+	return func(ps *PokemonService) {
+		ps.names = names
+	}
+}
+
+func WithSearchIndex(index *search.Index) Option {
+	return func(ps *PokemonService) {
+		ps.search = index
+	}
+}
+
+// WithRules picks translation styles with the rules held by engine instead of
+// rules.Default
+func WithRules(engine *rules.Engine) Option {
+	return func(ps *PokemonService) {
+		ps.styleRules = engine
+	}
+}
+
+func NewPokemonService(cache types.Cache, translator Translator, source SpeciesSource, opts ...Option) *PokemonService {
 	svc := PokemonService{
 		cache:      cache,
 		translator: translator,
-		baseURL:    parsed,
-		client:     client,
+		source:     source,
+		popularity: popularity.NewTracker(),
+		names:      canon.NewCanonicalizer(),
+		search:     search.NewIndex(),
+	}
+	for _, opt := range opts {
+		opt(&svc)
 	}
-	svc.getPokemonFromAPIfunc = svc.getPokemonFromAPI
-	return &svc, nil
+	return &svc
 }
 
 func normalize(s string) string {
-	return utils.RemoveWhitespace(strings.ToLower(s))
+	return canon.Slugify(s)
 }
 
 func getDescription(entries *[]FlavorTextEntry) string {
@@ -80,108 +139,295 @@ func getDescription(entries *[]FlavorTextEntry) string {
 	return (*entries)[0].FlavorText
 }
 
+func getVarieties(varieties []SpeciesVariety) (names []string, defaultVariety string) {
+	for _, v := range varieties {
+		names = append(names, v.Pokemon.Name)
+		if v.IsDefault {
+			defaultVariety = v.Pokemon.Name
+		}
+	}
+	return names, defaultVariety
+}
+
 func (pkmn *APIPokemon) toInternal() types.Pokemon {
+	varieties, defaultVariety := getVarieties(pkmn.Varieties)
 	return types.Pokemon{
-		IsLegendary: pkmn.IsLegendary,
-		Name:        pkmn.Name,
-		Habitat:     pkmn.APIHabitat.Name,
-		Desc:        utils.RemoveWhitespace(getDescription(&pkmn.FlavorTextEntries)),
+		IsLegendary:    pkmn.IsLegendary,
+		IsMythical:     pkmn.IsMythical,
+		Name:           pkmn.Name,
+		Habitat:        pkmn.APIHabitat.Name,
+		Generation:     pkmn.Generation.Name,
+		Desc:           utils.RemoveWhitespace(getDescription(&pkmn.FlavorTextEntries)),
+		Varieties:      varieties,
+		DefaultVariety: defaultVariety,
 	}
 }
 
-func (ps *PokemonService) getPokemonURL(name string) string {
-	rel, _ := url.Parse(name)
-	return ps.baseURL.ResolveReference(rel).String()
+// fetched is what getCached's fetches share, remembering whether the value
+// came from a fallback source
+type fetched[T any] struct {
+	value    T
+	fellBack bool
 }
 
-func (ps *PokemonService) groupedGetPokemonFromAPI(ctx context.Context, name string) (*types.Pokemon, error) {
-	pkmn, err, shared := ps.group.Do(name, func() (interface{}, error) {
-		return ps.getPokemonFromAPIfunc(ctx, name)
-	})
-
-	if shared {
-		slog.Debug("shared request for poke api", "key", name)
+// getCached returns the value stored in the cache under key, or fetches it
+// (deduplicating concurrent fetches) and stores it. Values served by a
+// fallback source are not stored, so that they are fetched again once the
+// main source is back.
+func getCached[T any](ctx context.Context, ps *PokemonService, key string, fetch func(context.Context) (T, error)) (T, error) {
+	cached, exists := ps.cache.Get(key)
+	if exists {
+		return cached.(T), nil
 	}
 
-	return pkmn.(*types.Pokemon), err
-}
-
-func (ps *PokemonService) getPokemonFromAPI(ctx context.Context, name string) (*types.Pokemon, error) {
-	url := ps.getPokemonURL(name)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("%w while creating req to retrieve pokemon from api with url %s: %v", types.ErrGeneric, url, err)
+	result, err, shared := ps.group.Do(key, func() (interface{}, error) {
+		ctx, fellBack := withFallbackReport(ctx)
+		value, err := fetch(ctx)
+		return fetched[T]{value: value, fellBack: fellBack.Load()}, err
+	})
+	if shared {
+		slog.Debug("shared request for species source", "key", key)
 	}
-	resp, err := ps.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("%w while trying to retrieve pokemon from api url %s: %v", types.ErrGeneric, url, err)
+		var zero T
+		return zero, err
 	}
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("%w while searching for pokemon %s", types.ErrNotFound, name)
 
-	} else if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-		return nil, fmt.Errorf("%w unexpected status %d from upstream while searching for pokemon %s: %s", types.ErrGeneric, resp.StatusCode, name, string(bodyBytes))
+	f := result.(fetched[T])
+	if !f.fellBack {
+		ps.cache.Put(key, f.value)
 	}
+	return f.value, nil
+}
 
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+func (ps *PokemonService) getPokemonFromSource(ctx context.Context, name string) (*types.Pokemon, error) {
+	apiPokemon, err := ps.source.Species(ctx, name)
 	if err != nil {
-		return nil, fmt.Errorf("%w while reading response body for pokemon %s: %v", types.ErrGeneric, name, err)
+		return nil, err
 	}
-
-	var apiPokemon APIPokemon
-	if err := json.Unmarshal(body, &apiPokemon); err != nil {
-		return nil, fmt.Errorf("%w while unmarshaling response for pokemon %s: %v", types.ErrGeneric, name, err)
+	for _, localized := range apiPokemon.Names {
+		if err := ps.names.AddAlias(localized.Name, apiPokemon.Name); err != nil {
+			slog.Warn("ignored localized pokemon name", "name", localized.Name, "language", localized.Language.Name, "error", err)
+		}
 	}
 
 	internal := apiPokemon.toInternal()
+	ps.search.Add(internal.Name, "description", internal.Desc)
 	return &internal, nil
 }
 
-func determineTranslationType(pkmn *types.Pokemon) types.Translation {
-	if strings.ToLower(pkmn.Habitat) == "cave" || pkmn.IsLegendary {
-		return types.Yoda
+func (ps *PokemonService) ruleSet() *rules.RuleSet {
+	if ps.styleRules == nil {
+		return &rules.Default
+	}
+	return ps.styleRules.Rules()
+}
+
+// determineTranslationType picks the style of the first matching rule,
+// looking up the pokemon's types only when some rule needs them
+func (ps *PokemonService) determineTranslationType(ctx context.Context, pkmn *types.Pokemon, form *types.Variety) types.Translation {
+	rs := ps.ruleSet()
+	subject := rules.Subject{Pokemon: pkmn}
+	if rs.NeedsTypes() {
+		if form == nil {
+			var err error
+			form, err = ps.getVariety(ctx, cmp.Or(pkmn.DefaultVariety, pkmn.Name))
+			if err != nil {
+				slog.Warn("cannot get types for translation rules", "pokemon", pkmn.Name, "error", err)
+			}
+		}
+		if form != nil {
+			subject.Types = form.Types
+		}
 	}
-	return types.Shakespeare
+	return rs.Pick(subject)
 }
 
 func (ps *PokemonService) getPokemon(ctx context.Context, name string) (*types.Pokemon, error) {
-	cached, exists := ps.cache.Get(name)
-	if exists {
-		return cached.(*types.Pokemon), nil
+	return getCached(ctx, ps, name, func(ctx context.Context) (*types.Pokemon, error) {
+		return ps.getPokemonFromSource(ctx, name)
+	})
+}
+
+// resolvePokemon looks name up as a species first and, failing that, as a
+// variety (e.g. "deoxys-attack" or "vulpix-alola"), returning its species
+// together with the variety
+func (ps *PokemonService) resolvePokemon(ctx context.Context, name string) (*types.Pokemon, *types.Variety, error) {
+	pkmn, err := ps.getPokemon(ctx, name)
+	if err == nil || !errors.Is(err, types.ErrNotFound) {
+		return pkmn, nil, err
 	}
 
-	internal, err := ps.groupedGetPokemonFromAPI(ctx, name)
+	variety, varietyErr := ps.getVariety(ctx, name)
+	if varietyErr != nil {
+		if !errors.Is(varietyErr, types.ErrNotFound) {
+			return nil, nil, varietyErr
+		}
+		return nil, nil, err
+	}
+
+	pkmn, err = ps.getPokemon(ctx, variety.Species)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	ps.cache.Put(name, internal)
-	return internal, nil
+	return pkmn, variety, nil
 }
 
 func (ps *PokemonService) GetPokemon(ctx context.Context, name string, translate bool) (*types.GetPokemonResult, error) {
-	name = normalize(name)
-	pkmn, err := ps.getPokemon(ctx, name)
+	return ps.searchPokemon(ctx, name, translate, "")
+}
+
+// GetTranslatedPokemon translates the description with style, or with the
+// style picked by determineTranslationType when style is empty
+func (ps *PokemonService) GetTranslatedPokemon(ctx context.Context, name string, style types.Translation) (*types.GetPokemonResult, error) {
+	return ps.searchPokemon(ctx, name, true, style)
+}
+
+// searchPokemon looks name up and counts it towards popularity
+func (ps *PokemonService) searchPokemon(ctx context.Context, name string, translate bool, style types.Translation) (*types.GetPokemonResult, error) {
+	name = ps.names.Canonicalize(name)
+	result, err := ps.getPokemonResult(ctx, name, translate, style)
+	if err != nil {
+		return nil, err
+	}
+
+	species := name
+	if result.Form != nil {
+		species = result.Form.Species
+	}
+	ps.popularity.Record(species)
+	return result, nil
+}
+
+func (ps *PokemonService) GetPopularPokemon(window string, limit int) (*types.GetPopularResult, error) {
+	duration, err := popularity.ParseWindow(window)
+	if err != nil {
+		return nil, err
+	}
+	if window == "" {
+		window = "all"
+	}
+
+	return &types.GetPopularResult{Window: window, Pokemon: ps.popularity.Top(duration, limit)}, nil
+}
+
+// translationWarning explains why the description of pokemon name couldn't
+// be translated
+func translationWarning(name string, err error) types.Warning {
+	warning := types.Warning{Code: types.WarningTranslationFailed, Message: "translation failed"}
+	var providerErr *types.ProviderError
+	if errors.As(err, &providerErr) {
+		warning.Provider = providerErr.Provider
+	}
+
+	var rateLimit *types.RateLimitError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &rateLimit):
+		retryAt := rateLimit.RetryAt.UTC()
+		warning.Code = types.WarningRateLimited
+		if rateLimit.Quota {
+			warning.Code = types.WarningQuotaExhausted
+		}
+		warning.Message = fmt.Sprintf("translation rate limited, available again at %s", retryAt.Format(time.RFC3339))
+		warning.RetryAfter = &retryAt
+	case errors.Is(err, upstream.ErrCircuitOpen):
+		warning.Code = types.WarningUpstreamUnavailable
+		warning.Message = "translation service unavailable"
+	case errors.Is(err, types.ErrTooManyRequests):
+		warning.Code = types.WarningRateLimited
+		warning.Message = "translation rate limited"
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		warning.Code = types.WarningTimeout
+		warning.Message = "translation timed out"
+	case errors.Is(err, types.ErrUnauthorized):
+		warning.Code = types.WarningUnauthorized
+		warning.Message = "translation service rejected our credentials"
+	}
+
+	// rate limits and open breakers are expected, and logged where they happen
+	switch warning.Code {
+	case types.WarningRateLimited, types.WarningQuotaExhausted, types.WarningUpstreamUnavailable:
+	default:
+		slog.Error("failed to translate description", "pokemon", name, "code", warning.Code, "error", err)
+	}
+	return warning
+}
+
+// legacyWarning is the message of warning as returned before warnings were
+// structured, when they only told rate limits with a known end apart
+func legacyWarning(warning types.Warning) string {
+	if warning.RetryAfter != nil {
+		return warning.Message
+	}
+	return "translation failed"
+}
+
+func (ps *PokemonService) getPokemonResult(ctx context.Context, name string, translate bool, style types.Translation) (*types.GetPokemonResult, error) {
+	pkmn, form, err := ps.resolvePokemon(ctx, name)
 	if err != nil {
 		return nil, err
 	}
 
 	if !translate || pkmn.Desc == "" {
-		return &types.GetPokemonResult{Pokemon: pkmn, Warnings: nil}, nil
+		return &types.GetPokemonResult{Pokemon: pkmn, Form: form, Warnings: nil}, nil
 	}
 
-	translation := determineTranslationType(pkmn)
-	translated, err := ps.translator.Translate(ctx, name, pkmn.Desc, translation)
+	if ps.popularity.IsTop(pkmn.Name, priorityWindow, priorityRank) {
+		ctx = types.WithPriority(ctx, types.HighPriority)
+	}
+	result, err := ps.translateResult(ctx, pkmn, form, style)
 	if err != nil {
-		if !errors.Is(types.ErrTooManyRequests, err) {
-			slog.Error("failed to translate description", "pokemon", name, "error", err)
+		// a style chosen by the caller must be valid
+		if style != "" && errors.Is(err, types.ErrInvalidInput) {
+			return nil, err
 		}
-		return &types.GetPokemonResult{Pokemon: pkmn, Warnings: []string{"translation failed"}}, nil
+		warning := translationWarning(name, err)
+		return &types.GetPokemonResult{Pokemon: pkmn, Form: form, Warnings: []string{legacyWarning(warning)}, WarningDetails: []types.Warning{warning}}, nil
 	}
+	return result, nil
+}
 
+// TranslatePokemon is like GetTranslatedPokemon, but fails when the
+// description cannot be translated exactly instead of returning it
+// untranslated or approximated. It is meant for background jobs, which can
+// retry later.
+func (ps *PokemonService) TranslatePokemon(ctx context.Context, name string, style types.Translation) (*types.GetPokemonResult, error) {
+	ctx = types.WithExactTranslation(ctx)
+	pkmn, form, err := ps.resolvePokemon(ctx, ps.names.Canonicalize(name))
+	if err != nil {
+		return nil, err
+	}
+	if pkmn.Desc == "" {
+		return &types.GetPokemonResult{Pokemon: pkmn, Form: form, Warnings: nil}, nil
+	}
+	return ps.translateResult(ctx, pkmn, form, style)
+}
+
+// translateResult translates the description with style, or with the style
+// picked by determineTranslationType when style is empty
+func (ps *PokemonService) translateResult(ctx context.Context, pkmn *types.Pokemon, form *types.Variety, style types.Translation) (*types.GetPokemonResult, error) {
+	translation := style
+	if translation == "" {
+		translation = ps.determineTranslationType(ctx, pkmn, form)
+	}
+	translated, engine, err := ps.translate(ctx, pkmn, translation)
+	if err != nil {
+		return nil, err
+	}
+
+	ps.search.Add(pkmn.Name, string(translation), *translated)
 	p := *pkmn
 	p.Desc = *translated
-	return &types.GetPokemonResult{Pokemon: &p, Warnings: nil}, nil
+	return &types.GetPokemonResult{Pokemon: &p, Form: form, Translation: translation, Engine: engine, Warnings: nil}, nil
+}
+
+// translate returns the translation together with the engine that produced
+// it, for translators that report one
+func (ps *PokemonService) translate(ctx context.Context, pkmn *types.Pokemon, translation types.Translation) (*string, string, error) {
+	if chain, ok := ps.translator.(providerTranslator); ok {
+		return chain.TranslateWithProvider(ctx, pkmn.Name, pkmn.Desc, translation)
+	}
+	translated, err := ps.translator.Translate(ctx, pkmn.Name, pkmn.Desc, translation)
+	return translated, "", err
 }
@@ -1,21 +1,28 @@
 package pokemon
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/sbaglivi/TL-Pokedex/cache"
+	"github.com/sbaglivi/TL-Pokedex/popularity"
+	"github.com/sbaglivi/TL-Pokedex/rules"
 	"github.com/sbaglivi/TL-Pokedex/translate"
 	"github.com/sbaglivi/TL-Pokedex/types"
+	"github.com/sbaglivi/TL-Pokedex/upstream"
 	"github.com/stretchr/testify/assert"
-	"golang.org/x/sync/singleflight"
 )
 
 func TestAPIPokemonToInternal(t *testing.T) {
@@ -76,10 +83,7 @@ func TestGetTranslatedPokemon(t *testing.T) {
 	}))
 	defer pkmnServer.Close()
 
-	pkmnService, err := NewPokemonService(cache, translationService, pkmnServer.URL, pkmnServer.Client())
-	if err != nil {
-		t.Fatalf("failed to create pokemonService: %v", err)
-	}
+	pkmnService := NewPokemonService(cache, translationService, newHTTPSource(t, pkmnServer))
 	ctx := context.Background()
 	result, err := pkmnService.GetPokemon(ctx, "groudon", false)
 	if err != nil {
@@ -141,10 +145,7 @@ func TestPokemonCachingBehavior(t *testing.T) {
 	}))
 	defer pkmnServer.Close()
 
-	pkmnService, err := NewPokemonService(cache, translationService, pkmnServer.URL, pkmnServer.Client())
-	if err != nil {
-		t.Fatalf("creating pokemon service: %v", err)
-	}
+	pkmnService := NewPokemonService(cache, translationService, newHTTPSource(t, pkmnServer))
 
 	ctx := context.Background()
 	result, err := pkmnService.GetPokemon(ctx, "groudon", true)
@@ -187,27 +188,828 @@ func TestPokemonCachingBehavior(t *testing.T) {
 	}
 }
 
-func TestPokemonServiceSingleflight(t *testing.T) {
-	var calls int32
-	svc := &PokemonService{
-		group: singleflight.Group{},
+func newHTTPSource(t *testing.T, srv *httptest.Server) *HTTPSource {
+	t.Helper()
+	source, err := NewHTTPSource(srv.URL, srv.Client())
+	if err != nil {
+		t.Fatalf("creating http source: %v", err)
 	}
+	return source
+}
 
-	svc.getPokemonFromAPIfunc = func(ctx context.Context, name string) (*types.Pokemon, error) {
-		atomic.AddInt32(&calls, 1)
-		time.Sleep(100 * time.Millisecond)
-		return &types.Pokemon{Name: name}, nil
+// fakeSource serves species from memory, counting how many times each is
+// requested
+type fakeSource struct {
+	Dataset
+	delay time.Duration
+	calls int32
+}
+
+func newFakeSource(species ...APIPokemon) *fakeSource {
+	src := fakeSource{Dataset: Dataset{snapshot: Snapshot{Species: make(map[string]APIPokemon)}}}
+	for _, s := range species {
+		src.snapshot.Species[s.Name] = s
 	}
+	return &src
+}
+
+func (src *fakeSource) Species(ctx context.Context, name string) (*APIPokemon, error) {
+	atomic.AddInt32(&src.calls, 1)
+	time.Sleep(src.delay)
+	return src.Dataset.Species(ctx, name)
+}
+
+func TestPokemonServiceSingleflight(t *testing.T) {
+	source := newFakeSource(APIPokemon{Name: "pikachu"})
+	source.delay = 100 * time.Millisecond
+	svc := NewPokemonService(cache.NewLRU(10), nil, source)
 
 	wg := sync.WaitGroup{}
 	for i := 0; i < 5; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			_, _ = svc.groupedGetPokemonFromAPI(context.Background(), "pikachu")
+			_, _ = svc.GetPokemon(context.Background(), "pikachu", false)
 		}()
 	}
 	wg.Wait()
 
-	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "expected only one API call")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&source.calls), "expected only one source call")
+}
+
+func TestGetPokemonFromFakeSource(t *testing.T) {
+	source := newFakeSource(APIPokemon{
+		Name:       "onix",
+		APIHabitat: NameAndURL{Name: "cave"},
+		FlavorTextEntries: []FlavorTextEntry{
+			{FlavorText: "As it grows,\nthe stone portions\fof its body harden.", Language: NameAndURL{Name: "en"}},
+		},
+	})
+	svc := NewPokemonService(cache.NewLRU(10), nil, source)
+
+	result, err := svc.GetPokemon(context.Background(), "Onix", false)
+	if err != nil {
+		t.Fatalf("GetPokemon('Onix') failed: %v", err)
+	}
+	assert.Equal(t, types.Pokemon{Name: "onix", Habitat: "cave", Desc: "As it grows, the stone portions of its body harden."}, *result.Pokemon)
+
+	_, err = svc.GetPokemon(context.Background(), "missingno", false)
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+type failingTranslator struct {
+	err error
+}
+
+func (ft failingTranslator) Translate(context.Context, string, string, types.Translation) (*string, error) {
+	return nil, ft.err
+}
+
+func TestTranslationWarnings(t *testing.T) {
+	source := newFakeSource(APIPokemon{
+		Name:              "pikachu",
+		FlavorTextEntries: []FlavorTextEntry{{FlavorText: "It stores electricity.", Language: NameAndURL{Name: "en"}}},
+	})
+	retryAt := time.Date(2025, 1, 1, 11, 0, 0, 0, time.UTC)
+	limited := "translation rate limited, available again at 2025-01-01T11:00:00Z"
+	cases := []struct {
+		err      error
+		expected string
+		warning  types.Warning
+	}{
+		{&types.RateLimitError{RetryAt: retryAt}, limited, types.Warning{Code: types.WarningRateLimited, Message: limited, RetryAfter: &retryAt}},
+		{&types.RateLimitError{RetryAt: retryAt, Quota: true}, limited, types.Warning{Code: types.WarningQuotaExhausted, Message: limited, RetryAfter: &retryAt}},
+		{types.ErrTooManyRequests, "translation failed", types.Warning{Code: types.WarningRateLimited, Message: "translation rate limited"}},
+		{fmt.Errorf("%w: %w", types.ErrTooManyRequests, upstream.ErrCircuitOpen), "translation failed", types.Warning{Code: types.WarningUpstreamUnavailable, Message: "translation service unavailable"}},
+		{fmt.Errorf("%w while making translation request: %w", types.ErrGeneric, context.DeadlineExceeded), "translation failed", types.Warning{Code: types.WarningTimeout, Message: "translation timed out"}},
+		{fmt.Errorf("%w: check the API secret", types.ErrUnauthorized), "translation failed", types.Warning{Code: types.WarningUnauthorized, Message: "translation service rejected our credentials"}},
+		{types.ErrGeneric, "translation failed", types.Warning{Code: types.WarningTranslationFailed, Message: "translation failed"}},
+		{&types.ProviderError{Provider: "funtranslations", Err: types.ErrGeneric}, "translation failed", types.Warning{Code: types.WarningTranslationFailed, Message: "translation failed", Provider: "funtranslations"}},
+	}
+	for _, c := range cases {
+		svc := NewPokemonService(cache.NewLRU(10), failingTranslator{c.err}, source)
+		result, err := svc.GetPokemon(context.Background(), "pikachu", true)
+		if err != nil {
+			t.Fatalf("GetPokemon failed: %v", err)
+		}
+		assert.Equal(t, "It stores electricity.", result.Pokemon.Desc)
+		assert.Equal(t, []string{c.expected}, result.Warnings, c.err.Error())
+		assert.Equal(t, []types.Warning{c.warning}, result.WarningDetails, c.err.Error())
+	}
+}
+
+// priorityTranslator records the priority of each translation
+type priorityTranslator struct {
+	priorities map[string]types.Priority
+}
+
+func (pt *priorityTranslator) Translate(ctx context.Context, key, value string, translation types.Translation) (*string, error) {
+	pt.priorities[key] = types.PriorityFrom(ctx)
+	return &value, nil
+}
+
+func TestPopularPokemonAreTranslatedWithHighPriority(t *testing.T) {
+	var species []APIPokemon
+	for _, name := range []string{"pikachu", "zubat"} {
+		species = append(species, APIPokemon{
+			Name:              name,
+			FlavorTextEntries: []FlavorTextEntry{{FlavorText: "A description.", Language: NameAndURL{Name: "en"}}},
+		})
+	}
+	tracker := popularity.NewTracker()
+	for range 3 {
+		tracker.Record("pikachu")
+	}
+	translator := &priorityTranslator{priorities: make(map[string]types.Priority)}
+	svc := NewPokemonService(cache.NewLRU(10), translator, newFakeSource(species...), WithPopularityTracker(tracker))
+
+	for _, name := range []string{"pikachu", "zubat"} {
+		if _, err := svc.GetPokemon(context.Background(), name, true); err != nil {
+			t.Fatalf("GetPokemon(%s) failed: %v", name, err)
+		}
+	}
+	assert.Equal(t, map[string]types.Priority{"pikachu": types.HighPriority, "zubat": types.NormalPriority}, translator.priorities)
+}
+
+func TestGetTranslatedPokemonWithStyle(t *testing.T) {
+	var paths []string
+	translationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		style := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".json")
+		_, _ = w.Write([]byte(`{"success":{"total":1},"contents":{"translated":"in ` + style + `"}}`))
+	}))
+	defer translationServer.Close()
+
+	lru := cache.NewLRU(10)
+	translationService, err := translate.NewTranslationService(lru, translationServer.URL, translationServer.Client())
+	if err != nil {
+		t.Fatalf("creating translation service: %v", err)
+	}
+	source := newFakeSource(APIPokemon{
+		Name:              "onix",
+		APIHabitat:        NameAndURL{Name: "cave"},
+		FlavorTextEntries: []FlavorTextEntry{{FlavorText: "It burrows.", Language: NameAndURL{Name: "en"}}},
+	})
+	svc := NewPokemonService(lru, translationService, source)
+	ctx := context.Background()
+
+	for _, c := range []struct {
+		style    types.Translation
+		expected types.Translation
+	}{
+		{"", types.Yoda},
+		{types.Pirate, types.Pirate},
+		{types.Pirate, types.Pirate},
+		{types.Yoda, types.Yoda},
+	} {
+		result, err := svc.GetTranslatedPokemon(ctx, "onix", c.style)
+		if err != nil {
+			t.Fatalf("GetTranslatedPokemon(onix, %s) failed: %v", c.style, err)
+		}
+		assert.Equal(t, c.expected, result.Translation)
+		assert.Equal(t, "in "+string(c.expected), result.Pokemon.Desc)
+	}
+	assert.Equal(t, []string{"/yoda.json", "/pirate.json"}, paths, "each style should be translated once")
+
+	_, err = svc.GetTranslatedPokemon(ctx, "onix", "elvish")
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+}
+
+// styleTranslator translates descriptions into the name of the style
+type styleTranslator struct{}
+
+func (styleTranslator) Translate(ctx context.Context, key, value string, translation types.Translation) (*string, error) {
+	translated := string(translation)
+	return &translated, nil
+}
+
+func TestTranslatePokemonFailsWithoutTranslation(t *testing.T) {
+	source := newFakeSource(APIPokemon{
+		Name:              "pikachu",
+		FlavorTextEntries: []FlavorTextEntry{{FlavorText: "It stores electricity.", Language: NameAndURL{Name: "en"}}},
+	})
+	retryAt := time.Date(2025, 1, 1, 11, 0, 0, 0, time.UTC)
+	svc := NewPokemonService(cache.NewLRU(10), failingTranslator{&types.RateLimitError{RetryAt: retryAt}}, source)
+
+	_, err := svc.TranslatePokemon(context.Background(), "Pikachu", types.Yoda)
+	var rateLimit *types.RateLimitError
+	assert.ErrorAs(t, err, &rateLimit)
+	assert.Equal(t, retryAt, rateLimit.RetryAt)
+
+	// jobs wait for a real translation rather than settle for an approximation
+	chain := translate.NewChain(
+		translate.Provider{Name: "funtranslations", Translator: failingTranslator{&types.RateLimitError{RetryAt: retryAt}}},
+		translate.Provider{Name: "local", Translator: translate.NewLocal(), Approximate: true},
+	)
+	svc = NewPokemonService(cache.NewLRU(10), chain, source)
+	_, err = svc.TranslatePokemon(context.Background(), "pikachu", types.Yoda)
+	assert.ErrorAs(t, err, &rateLimit)
+
+	svc = NewPokemonService(cache.NewLRU(10), styleTranslator{}, source)
+	result, err := svc.TranslatePokemon(context.Background(), "pikachu", types.Pirate)
+	assert.NoError(t, err)
+	assert.Equal(t, "pirate", result.Pokemon.Desc)
+	assert.Empty(t, svc.popularity.Top(0, 10), "background translations are not searches")
+}
+
+func TestTranslatorChain(t *testing.T) {
+	source := newFakeSource(
+		APIPokemon{
+			Name:              "onix",
+			APIHabitat:        NameAndURL{Name: "cave"},
+			FlavorTextEntries: []FlavorTextEntry{{FlavorText: "It burrows through the ground.", Language: NameAndURL{Name: "en"}}},
+		},
+	)
+	unavailable := failingTranslator{fmt.Errorf("%w: funtranslations is down", types.ErrGeneric)}
+
+	chain := func(remote Translator) *translate.Chain {
+		return translate.NewChain(
+			translate.Provider{Name: "funtranslations", Translator: remote},
+			translate.Provider{Name: "local", Translator: translate.NewLocal()},
+		)
+	}
+
+	svc := NewPokemonService(cache.NewLRU(10), chain(unavailable), source)
+	result, err := svc.GetTranslatedPokemon(context.Background(), "onix", "")
+	if err != nil {
+		t.Fatalf("GetTranslatedPokemon failed: %v", err)
+	}
+	assert.Equal(t, "Through the ground, it burrows.", result.Pokemon.Desc)
+	assert.Equal(t, types.Yoda, result.Translation)
+	assert.Equal(t, "local", result.Engine)
+	assert.Empty(t, result.Warnings)
+
+	// styles the local translator cannot handle keep the remote warning
+	result, err = svc.GetTranslatedPokemon(context.Background(), "onix", types.Klingon)
+	if err != nil {
+		t.Fatalf("GetTranslatedPokemon failed: %v", err)
+	}
+	assert.Equal(t, "It burrows through the ground.", result.Pokemon.Desc)
+	assert.Empty(t, result.Engine)
+	assert.Equal(t, []string{"translation failed"}, result.Warnings)
+
+	// invalid styles are not retried locally
+	invalid := failingTranslator{fmt.Errorf("%w: unknown style", types.ErrInvalidInput)}
+	svc = NewPokemonService(cache.NewLRU(10), chain(invalid), source)
+	_, err = svc.GetTranslatedPokemon(context.Background(), "onix", types.Yoda)
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+}
+
+func TestTranslationRules(t *testing.T) {
+	dataset, err := EmbeddedDataset()
+	if err != nil {
+		t.Fatalf("loading embedded dataset: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "rules.json")
+	doc := `{"rules": [
+		{"when": {"mythical": true}, "style": "sith"},
+		{"when": {"type": ["water"]}, "style": "pirate"},
+		{"when": {"generation": ["generation-i"], "habitat": ["forest"]}, "style": "pig-latin"}
+	], "default": "shakespeare"}`
+	if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+		t.Fatalf("writing rules: %v", err)
+	}
+	engine, err := rules.NewEngine(path, func(types.Translation) error { return nil })
+	if err != nil {
+		t.Fatalf("loading rules: %v", err)
+	}
+	svc := NewPokemonService(cache.NewLRU(100), styleTranslator{}, dataset, WithRules(engine))
+
+	for name, expected := range map[string]types.Translation{
+		"mew":        types.Sith,
+		"squirtle":   types.Pirate,
+		"pikachu":    types.PigLatin,
+		"charmander": types.Shakespeare,
+	} {
+		result, err := svc.GetTranslatedPokemon(context.Background(), name, "")
+		if err != nil {
+			t.Fatalf("GetTranslatedPokemon(%s) failed: %v", name, err)
+		}
+		assert.Equal(t, expected, result.Translation, name)
+	}
+}
+
+func TestGetRandomPokemonIsDeterministicForSeed(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pokemon-species/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/pokemon-species/")
+		if name == "" {
+			_, _ = w.Write([]byte(`{"results":[{"name":"zubat"},{"name":"pikachu"},{"name":"onix"},{"name":"mew"}]}`))
+			return
+		}
+		bytes, _ := json.Marshal(APIPokemon{Name: name})
+		_, _ = w.Write(bytes)
+	})
+	mux.HandleFunc("/pokemon-habitat/cave", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"pokemon_species":[{"name":"zubat"},{"name":"onix"}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	pkmnService := NewPokemonService(cache.NewLRU(10), nil, newHTTPSource(t, srv))
+
+	ctx := context.Background()
+	first, err := pkmnService.GetRandomPokemon(ctx, types.RandomOptions{Seed: "2025-01-01"}, false)
+	if err != nil {
+		t.Fatalf("GetRandomPokemon failed: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		again, err := pkmnService.GetRandomPokemon(ctx, types.RandomOptions{Seed: "2025-01-01"}, false)
+		if err != nil {
+			t.Fatalf("GetRandomPokemon failed: %v", err)
+		}
+		assert.Equal(t, first.Pokemon.Name, again.Pokemon.Name)
+	}
+
+	for i := 0; i < 10; i++ {
+		result, err := pkmnService.GetRandomPokemon(ctx, types.RandomOptions{Habitat: "Cave"}, false)
+		if err != nil {
+			t.Fatalf("GetRandomPokemon with habitat failed: %v", err)
+		}
+		assert.Contains(t, []string{"zubat", "onix"}, result.Pokemon.Name)
+	}
+}
+
+func TestGetPokemonRecordsPopularity(t *testing.T) {
+	pkmnServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/missingno") {
+			w.WriteHeader(404)
+			return
+		}
+		_, _ = w.Write([]byte(`{"name":"pikachu"}`))
+	}))
+	defer pkmnServer.Close()
+
+	tracker := popularity.NewTracker()
+	pkmnService := NewPokemonService(cache.NewLRU(10), nil, newHTTPSource(t, pkmnServer), WithPopularityTracker(tracker))
+
+	ctx := context.Background()
+	_, _ = pkmnService.GetPokemon(ctx, "Pikachu", false)
+	_, _ = pkmnService.GetPokemon(ctx, "pikachu ", false)
+	_, _ = pkmnService.GetPokemon(ctx, "missingno", false)
+
+	result, err := pkmnService.GetPopularPokemon("24h", 10)
+	if err != nil {
+		t.Fatalf("GetPopularPokemon failed: %v", err)
+	}
+	assert.Equal(t, &types.GetPopularResult{Window: "24h", Pokemon: []types.PopularPokemon{{Name: "pikachu", Count: 2}}}, result)
+
+	_, err = pkmnService.GetPopularPokemon("forever", 10)
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+}
+
+// defensive type chart: attacking types dealing double, half and no damage
+var testTypeChart = map[string][3][]string{
+	"normal":   {{"fighting"}, {}, {"ghost"}},
+	"fire":     {{"water", "ground", "rock"}, {"fire", "grass", "ice", "bug", "steel", "fairy"}, {}},
+	"water":    {{"electric", "grass"}, {"fire", "water", "ice", "steel"}, {}},
+	"electric": {{"ground"}, {"electric", "flying", "steel"}, {}},
+	"grass":    {{"fire", "ice", "poison", "flying", "bug"}, {"water", "electric", "grass", "ground"}, {}},
+	"ice":      {{"fire", "fighting", "rock", "steel"}, {"ice"}, {}},
+	"fighting": {{"flying", "psychic", "fairy"}, {"bug", "rock", "dark"}, {}},
+	"poison":   {{"ground", "psychic"}, {"grass", "fighting", "poison", "bug", "fairy"}, {}},
+	"ground":   {{"water", "grass", "ice"}, {"poison", "rock"}, {"electric"}},
+	"flying":   {{"electric", "ice", "rock"}, {"grass", "fighting", "bug"}, {"ground"}},
+	"psychic":  {{"bug", "ghost", "dark"}, {"fighting", "psychic"}, {}},
+	"bug":      {{"fire", "flying", "rock"}, {"grass", "fighting", "ground"}, {}},
+	"rock":     {{"water", "grass", "fighting", "ground", "steel"}, {"normal", "fire", "poison", "flying"}, {}},
+	"ghost":    {{"ghost", "dark"}, {"poison", "bug"}, {"normal", "fighting"}},
+	"dragon":   {{"ice", "dragon", "fairy"}, {"fire", "water", "electric", "grass"}, {}},
+	"dark":     {{"fighting", "bug", "fairy"}, {"ghost", "dark"}, {"psychic"}},
+	"steel":    {{"fire", "fighting", "ground"}, {"normal", "grass", "ice", "flying", "psychic", "bug", "rock", "dragon", "steel", "fairy"}, {"poison"}},
+	"fairy":    {{"poison", "steel"}, {"fighting", "bug", "dark"}, {"dragon"}},
+}
+
+func toResources(names []string) []NameAndURL {
+	resources := []NameAndURL{}
+	for _, name := range names {
+		resources = append(resources, NameAndURL{Name: name})
+	}
+	return resources
+}
+
+func handleTypes(mux *http.ServeMux, calls *int32) {
+	mux.HandleFunc("/type/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		name := strings.TrimPrefix(r.URL.Path, "/type/")
+		relations, exists := testTypeChart[name]
+		if !exists {
+			w.WriteHeader(404)
+			return
+		}
+		bytes, _ := json.Marshal(APIType{Name: name, DamageRelations: DamageRelations{
+			DoubleDamageFrom: toResources(relations[0]),
+			HalfDamageFrom:   toResources(relations[1]),
+			NoDamageFrom:     toResources(relations[2]),
+		}})
+		_, _ = w.Write(bytes)
+	})
+}
+
+func TestGetTypeMatchup(t *testing.T) {
+	var calls int32
+	mux := http.NewServeMux()
+	handleTypes(mux, &calls)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	pkmnService := NewPokemonService(cache.NewLRU(10), nil, newHTTPSource(t, srv))
+
+	ctx := context.Background()
+	cases := []struct {
+		attacker string
+		defender []string
+		expected float64
+	}{
+		{"fire", []string{"grass", "steel"}, 4},
+		{"fire", []string{"grass"}, 2},
+		{"water", []string{"grass"}, 0.5},
+		{"normal", []string{"grass"}, 1},
+		{"poison", []string{"grass", "steel"}, 0},
+		{"grass", []string{"grass", "steel"}, 0.25},
+	}
+	for _, c := range cases {
+		matchup, err := pkmnService.GetTypeMatchup(ctx, c.attacker, c.defender)
+		if err != nil {
+			t.Fatalf("GetTypeMatchup(%s, %v) failed: %v", c.attacker, c.defender, err)
+		}
+		assert.Equal(t, c.expected, matchup.Multiplier, "%s vs %v", c.attacker, c.defender)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "type data should be cached")
+
+	_, err := pkmnService.GetTypeMatchup(ctx, "sound", []string{"grass"})
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+	_, err = pkmnService.GetTypeMatchup(ctx, "fire", []string{"grass", "steel", "water"})
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+	_, err = pkmnService.GetTypeMatchup(ctx, "fire", []string{"grass", " Grass"})
+	assert.ErrorIs(t, err, types.ErrInvalidInput, "a type can't defend twice")
+}
+
+func TestComparePokemon(t *testing.T) {
+	var calls int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pokemon-species/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		name := strings.TrimPrefix(r.URL.Path, "/pokemon-species/")
+		bytes, _ := json.Marshal(APIPokemon{
+			Name:       name,
+			APIHabitat: NameAndURL{Name: "forest"},
+			Varieties:  []SpeciesVariety{{IsDefault: true, Pokemon: NameAndURL{Name: name + "-default"}}},
+		})
+		_, _ = w.Write(bytes)
+	})
+	mux.HandleFunc("/pokemon/pikachu-default", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(`{"name":"pikachu","height":4,"weight":60,"types":[{"slot":1,"type":{"name":"electric"}}],
+			"stats":[{"base_stat":35,"stat":{"name":"hp"}},{"base_stat":90,"stat":{"name":"speed"}},{"base_stat":55,"stat":{"name":"attack"}}]}`))
+	})
+	mux.HandleFunc("/pokemon/raichu-default", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(`{"name":"raichu","height":8,"weight":300,"types":[{"slot":1,"type":{"name":"electric"}},{"slot":2,"type":{"name":"psychic"}}],
+			"stats":[{"base_stat":60,"stat":{"name":"hp"}},{"base_stat":90,"stat":{"name":"speed"}},{"base_stat":85,"stat":{"name":"attack"}}]}`))
+	})
+	var typeCalls int32
+	handleTypes(mux, &typeCalls)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	pkmnService := NewPokemonService(cache.NewLRU(20), nil, newHTTPSource(t, srv))
+
+	comparison, err := pkmnService.ComparePokemon(context.Background(), "Pikachu", "raichu")
+	if err != nil {
+		t.Fatalf("ComparePokemon failed: %v", err)
+	}
+	assert.Equal(t, []types.StatComparison{
+		{Name: "hp", A: 35, B: 60, Winner: "b"},
+		{Name: "speed", A: 90, B: 90, Winner: "tie"},
+		{Name: "attack", A: 55, B: 85, Winner: "b"},
+	}, comparison.Diff.Stats)
+	assert.Equal(t, types.TypesComparison{Shared: []string{"electric"}, OnlyA: []string{}, OnlyB: []string{"psychic"}}, comparison.Diff.Types)
+	assert.Equal(t, types.Difference[string]{A: "forest", B: "forest", Same: true}, comparison.Diff.Habitat)
+	assert.Equal(t, types.Difference[int]{A: 60, B: 300}, comparison.Diff.Weight)
+	assert.Equal(t, types.ComparisonSummary{AWins: 0, BWins: 2, Ties: 1, TotalA: 180, TotalB: 235, Winner: "b"}, comparison.Summary)
+	assert.Equal(t, map[string]float64{"ground": 2}, comparison.A.Defense.Weaknesses)
+	assert.Equal(t, map[string]float64{"ground": 2, "bug": 2, "ghost": 2, "dark": 2}, comparison.B.Defense.Weaknesses)
+	assert.Equal(t, map[string]float64{"flying": 0.5, "steel": 0.5, "electric": 0.5, "fighting": 0.5, "psychic": 0.5}, comparison.B.Defense.Resistances)
+
+	_, err = pkmnService.ComparePokemon(context.Background(), "pikachu", "raichu")
+	if err != nil {
+		t.Fatalf("ComparePokemon failed: %v", err)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls), "second comparison should be served from cache")
+
+	_, err = pkmnService.ComparePokemon(context.Background(), "pikachu", "mew")
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+func TestAnalyzeTeam(t *testing.T) {
+	varieties := map[string]string{
+		"pikachu":   `{"name":"pikachu","types":[{"slot":1,"type":{"name":"electric"}}],"stats":[{"base_stat":35,"stat":{"name":"hp"}},{"base_stat":55,"stat":{"name":"attack"}}]}`,
+		"onix":      `{"name":"onix","types":[{"slot":1,"type":{"name":"rock"}},{"slot":2,"type":{"name":"ground"}}],"stats":[{"base_stat":35,"stat":{"name":"hp"}},{"base_stat":45,"stat":{"name":"attack"}}]}`,
+		"charizard": `{"name":"charizard","types":[{"slot":1,"type":{"name":"fire"}},{"slot":2,"type":{"name":"flying"}}],"stats":[{"base_stat":78,"stat":{"name":"hp"}},{"base_stat":84,"stat":{"name":"attack"}}]}`,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pokemon-species/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/pokemon-species/")
+		if _, exists := varieties[name]; !exists {
+			w.WriteHeader(404)
+			return
+		}
+		bytes, _ := json.Marshal(APIPokemon{Name: name})
+		_, _ = w.Write(bytes)
+	})
+	mux.HandleFunc("/pokemon/", func(w http.ResponseWriter, r *http.Request) {
+		variety, exists := varieties[strings.TrimPrefix(r.URL.Path, "/pokemon/")]
+		if !exists {
+			w.WriteHeader(404)
+			return
+		}
+		_, _ = w.Write([]byte(variety))
+	})
+	var typeCalls int32
+	handleTypes(mux, &typeCalls)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	pkmnService := NewPokemonService(cache.NewLRU(100), nil, newHTTPSource(t, srv))
+
+	ctx := context.Background()
+	analysis, err := pkmnService.AnalyzeTeam(ctx, []string{"Pikachu", "onix", "charizard"})
+	if err != nil {
+		t.Fatalf("AnalyzeTeam failed: %v", err)
+	}
+
+	assert.Len(t, analysis.Members, 3)
+	assert.Equal(t, []string{"normal", "ground", "psychic", "ghost", "dragon", "dark", "fairy"}, analysis.UncoveredTypes)
+	assert.Len(t, analysis.Coverage, len(BattleTypes)-len(analysis.UncoveredTypes))
+	assert.Equal(t, map[string][]string{"water": {"onix", "charizard"}, "ground": {"pikachu", "onix"}}, analysis.SharedWeaknesses)
+	assert.Equal(t, []types.TeamVulnerability{
+		{Type: "water", Weak: []string{"onix", "charizard"}, Resistant: []string{}, Score: 2},
+		{Type: "ground", Weak: []string{"pikachu", "onix"}, Resistant: []string{"charizard"}, Score: 1},
+		{Type: "ice", Weak: []string{"onix"}, Resistant: []string{}, Score: 1},
+	}, analysis.Vulnerabilities)
+	assert.Equal(t, types.TeamStats{
+		Totals:  []types.Stat{{Name: "hp", Base: 148}, {Name: "attack", Base: 184}},
+		Members: map[string]int{"pikachu": 90, "onix": 80, "charizard": 162},
+		Total:   332,
+	}, analysis.Stats)
+
+	_, err = pkmnService.AnalyzeTeam(ctx, []string{"pikachu", "pikachu", "pikachu", "pikachu", "pikachu", "pikachu", "pikachu"})
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+	_, err = pkmnService.AnalyzeTeam(ctx, []string{"pikachu", "onix", " PIKACHU"})
+	assert.ErrorIs(t, err, types.ErrInvalidInput, "members must be different pokemon")
+	_, err = pkmnService.AnalyzeTeam(ctx, []string{"pikachu", "missingno"})
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+func TestGetPokemonVariety(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pokemon-species/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.TrimPrefix(r.URL.Path, "/pokemon-species/") != "vulpix" {
+			w.WriteHeader(404)
+			return
+		}
+		_, _ = w.Write([]byte(`{"name":"vulpix","habitat":{"name":"grassland"},"flavor_text_entries":[{"flavor_text":"It has six tails.","language":{"name":"en"}}],
+			"varieties":[{"is_default":true,"pokemon":{"name":"vulpix"}},{"is_default":false,"pokemon":{"name":"vulpix-alola"}}]}`))
+	})
+	mux.HandleFunc("/pokemon/vulpix-alola", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name":"vulpix-alola","species":{"name":"vulpix"},"height":6,"weight":99,"types":[{"slot":1,"type":{"name":"ice"}}],"stats":[{"base_stat":38,"stat":{"name":"hp"}}]}`))
+	})
+	mux.HandleFunc("/pokemon/missingno", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tracker := popularity.NewTracker()
+	pkmnService := NewPokemonService(cache.NewLRU(10), nil, newHTTPSource(t, srv), WithPopularityTracker(tracker))
+
+	ctx := context.Background()
+	result, err := pkmnService.GetPokemon(ctx, "vulpix", false)
+	if err != nil {
+		t.Fatalf("GetPokemon('vulpix') failed: %v", err)
+	}
+	assert.Nil(t, result.Form)
+	assert.Equal(t, []string{"vulpix", "vulpix-alola"}, result.Pokemon.Varieties)
+
+	result, err = pkmnService.GetPokemon(ctx, "Vulpix-Alola", false)
+	if err != nil {
+		t.Fatalf("GetPokemon('vulpix-alola') failed: %v", err)
+	}
+	assert.Equal(t, "vulpix", result.Pokemon.Name)
+	assert.Equal(t, "It has six tails.", result.Pokemon.Desc)
+	assert.Equal(t, &types.Variety{Name: "vulpix-alola", Species: "vulpix", Types: []string{"ice"}, Stats: []types.Stat{{Name: "hp", Base: 38}}, Height: 6, Weight: 99}, result.Form)
+	assert.Equal(t, []types.PopularPokemon{{Name: "vulpix", Count: 2}}, tracker.Top(0, 10))
+
+	_, err = pkmnService.GetPokemon(ctx, "missingno", false)
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+func TestGetPokemonCanonicalizesNames(t *testing.T) {
+	var paths []string
+	pkmnServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		name := strings.TrimPrefix(r.URL.Path, "/pokemon-species/")
+		bytes, _ := json.Marshal(APIPokemon{Name: name, Names: []LocalizedName{
+			{Name: "Pantimime", Language: NameAndURL{Name: "de"}},
+			{Name: "バリヤード", Language: NameAndURL{Name: "ja"}},
+		}})
+		_, _ = w.Write(bytes)
+	}))
+	defer pkmnServer.Close()
+
+	pkmnService := NewPokemonService(cache.NewLRU(10), nil, newHTTPSource(t, pkmnServer))
+
+	ctx := context.Background()
+	for _, name := range []string{"Mr. Mime", "mr mime", "バリヤード", "pantimime"} {
+		result, err := pkmnService.GetPokemon(ctx, name, false)
+		if err != nil {
+			t.Fatalf("GetPokemon(%q) failed: %v", name, err)
+		}
+		assert.Equal(t, "mr-mime", result.Pokemon.Name, name)
+	}
+	assert.Equal(t, []string{"/pokemon-species/mr-mime"}, paths)
+}
+
+func TestSearchIndexesFetchedDescriptions(t *testing.T) {
+	descriptions := map[string]string{
+		"zubat":      "Forms colonies in perpetually dark places. It sleeps in caves during the day.",
+		"charmander": "Obviously prefers hot places. When it rains, steam is said to spout from the tip of its tail.",
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pokemon-species/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/pokemon-species/")
+		if name == "" {
+			_, _ = w.Write([]byte(`{"results":[{"name":"zubat"},{"name":"charmander"}]}`))
+			return
+		}
+		bytes, _ := json.Marshal(APIPokemon{Name: name, FlavorTextEntries: []FlavorTextEntry{{FlavorText: descriptions[name]}}})
+		_, _ = w.Write(bytes)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	pkmnService := NewPokemonService(cache.NewLRU(10), nil, newHTTPSource(t, srv))
+
+	_, err := pkmnService.GetPokemon(context.Background(), "charmander", false)
+	if err != nil {
+		t.Fatalf("GetPokemon failed: %v", err)
+	}
+	result, err := pkmnService.Search("hot places", 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if assert.Len(t, result.Results, 1) {
+		assert.Equal(t, "charmander", result.Results[0].Name)
+	}
+
+	if err := pkmnService.IndexAllSpecies(context.Background(), 2); err != nil {
+		t.Fatalf("IndexAllSpecies failed: %v", err)
+	}
+	result, err = pkmnService.Search("places", 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	assert.Len(t, result.Results, 2)
+
+	_, err = pkmnService.Search("  ", 10)
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+}
+
+func TestOfflineDataset(t *testing.T) {
+	dataset, err := EmbeddedDataset()
+	if err != nil {
+		t.Fatalf("loading embedded dataset: %v", err)
+	}
+
+	pkmnService := NewPokemonService(cache.NewLRU(100), nil, dataset)
+
+	ctx := context.Background()
+	result, err := pkmnService.GetPokemon(ctx, "Mr. Mime", false)
+	if err != nil {
+		t.Fatalf("GetPokemon('Mr. Mime') failed: %v", err)
+	}
+	assert.Equal(t, "mr-mime", result.Pokemon.Name)
+	assert.Equal(t, []string{"mr-mime", "mr-mime-galar"}, result.Pokemon.Varieties)
+
+	result, err = pkmnService.GetPokemon(ctx, "deoxys-attack", false)
+	if err != nil {
+		t.Fatalf("GetPokemon('deoxys-attack') failed: %v", err)
+	}
+	assert.Equal(t, "deoxys", result.Pokemon.Name)
+	assert.Equal(t, "deoxys-attack", result.Form.Name)
+
+	result, err = pkmnService.GetRandomPokemon(ctx, types.RandomOptions{Habitat: "cave", Seed: "today"}, false)
+	if err != nil {
+		t.Fatalf("GetRandomPokemon failed: %v", err)
+	}
+	assert.Contains(t, []string{"zubat", "onix"}, result.Pokemon.Name)
+
+	matchup, err := pkmnService.GetTypeMatchup(ctx, "water", []string{"rock", "ground"})
+	if err != nil {
+		t.Fatalf("GetTypeMatchup failed: %v", err)
+	}
+	assert.Equal(t, 4.0, matchup.Multiplier)
+
+	_, err = pkmnService.AnalyzeTeam(ctx, []string{"pikachu", "onix", "charmander"})
+	assert.NoError(t, err)
+
+	_, err = pkmnService.GetPokemon(ctx, "missingno", false)
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+func TestFallbackSource(t *testing.T) {
+	dataset, err := EmbeddedDataset()
+	if err != nil {
+		t.Fatalf("loading embedded dataset: %v", err)
+	}
+
+	var calls int32
+	pkmnServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if strings.HasSuffix(r.URL.Path, "/missingno") {
+			w.WriteHeader(404)
+			return
+		}
+		w.WriteHeader(503)
+	}))
+	defer pkmnServer.Close()
+
+	pkmnService := NewPokemonService(cache.NewLRU(10), nil, NewFallbackSource(newHTTPSource(t, pkmnServer), dataset))
+
+	ctx := context.Background()
+	result, err := pkmnService.GetPokemon(ctx, "pikachu", false)
+	if err != nil {
+		t.Fatalf("GetPokemon should fall back to the dataset: %v", err)
+	}
+	assert.Equal(t, "forest", result.Pokemon.Habitat)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	_, err = pkmnService.GetPokemon(ctx, "pikachu", false)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "answers from the fallback must not be cached")
+
+	for range 2 {
+		_, err = pkmnService.GetRandomPokemon(ctx, types.RandomOptions{Habitat: "cave"}, false)
+		assert.NoError(t, err)
+	}
+	// each pick asks for the habitat index and then the species
+	assert.Equal(t, int32(6), atomic.LoadInt32(&calls), "indexes from the fallback must not be cached")
+
+	_, err = pkmnService.GetPokemon(ctx, "missingno", false)
+	assert.ErrorIs(t, err, types.ErrNotFound)
+
+	_, err = pkmnService.GetPokemon(ctx, "groudon", false)
+	assert.ErrorIs(t, err, types.ErrGeneric, "species missing from the dataset should report the upstream error")
+}
+
+func TestFetchSnapshot(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pokemon-species/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/pokemon-species/")
+		if name == "" {
+			_, _ = w.Write([]byte(`{"results":[{"name":"vulpix"},{"name":"zubat"}]}`))
+			return
+		}
+		bytes, _ := json.Marshal(APIPokemon{
+			Name:       name,
+			Generation: NameAndURL{Name: "generation-i"},
+			FlavorTextEntries: []FlavorTextEntry{
+				{FlavorText: "Il a une queue.", Language: NameAndURL{Name: "fr"}},
+				{FlavorText: "It has a tail.", Language: NameAndURL{Name: "en"}},
+			},
+			Varieties: []SpeciesVariety{{IsDefault: true, Pokemon: NameAndURL{Name: name}}},
+		})
+		_, _ = w.Write(bytes)
+	})
+	mux.HandleFunc("/pokemon/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/pokemon/")
+		_, _ = w.Write([]byte(`{"name":"` + name + `","species":{"name":"` + name + `"},"types":[{"slot":1,"type":{"name":"fire"}}]}`))
+	})
+	var typeCalls int32
+	handleTypes(mux, &typeCalls)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	snapshot, err := FetchSnapshot(context.Background(), newHTTPSource(t, srv), 1, 4)
+	if err != nil {
+		t.Fatalf("FetchSnapshot failed: %v", err)
+	}
+	assert.Len(t, snapshot.Species, 1)
+	assert.Len(t, snapshot.Varieties, 1)
+	assert.Len(t, snapshot.Types, len(BattleTypes))
+	assert.Equal(t, []FlavorTextEntry{{FlavorText: "It has a tail.", Language: NameAndURL{Name: "en"}}}, snapshot.Species["vulpix"].FlavorTextEntries)
+
+	var buf bytes.Buffer
+	if err := snapshot.Write(&buf); err != nil {
+		t.Fatalf("writing snapshot: %v", err)
+	}
+	dataset, err := LoadDataset(&buf)
+	if err != nil {
+		t.Fatalf("loading written snapshot: %v", err)
+	}
+	assert.Equal(t, 1, dataset.Len())
+	names, err := dataset.GenerationSpecies(context.Background(), "generation-i")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vulpix"}, names)
 }
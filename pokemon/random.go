@@ -0,0 +1,91 @@
+package pokemon
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+	"strings"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+)
+
+func namesOf(resources []NameAndURL) []string {
+	names := make([]string, 0, len(resources))
+	for _, r := range resources {
+		names = append(names, r.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (ps *PokemonService) getSpeciesNames(ctx context.Context, key string, fetch func(context.Context) ([]string, error)) ([]string, error) {
+	return getCached(ctx, ps, "index:"+key, fetch)
+}
+
+func intersect(a, b []string) []string {
+	result := []string{}
+	for _, name := range a {
+		if _, found := slices.BinarySearch(b, name); found {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func (ps *PokemonService) getCandidates(ctx context.Context, opts types.RandomOptions) ([]string, error) {
+	var lists [][]string
+	if opts.Habitat != "" {
+		habitat := normalize(opts.Habitat)
+		names, err := ps.getSpeciesNames(ctx, "habitat:"+habitat, func(ctx context.Context) ([]string, error) {
+			return ps.source.HabitatSpecies(ctx, habitat)
+		})
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, names)
+	}
+	if opts.Generation != "" {
+		generation := normalize(opts.Generation)
+		names, err := ps.getSpeciesNames(ctx, "generation:"+generation, func(ctx context.Context) ([]string, error) {
+			return ps.source.GenerationSpecies(ctx, generation)
+		})
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, names)
+	}
+	if len(lists) == 0 {
+		return ps.getSpeciesNames(ctx, "species", ps.source.SpeciesNames)
+	}
+
+	candidates := lists[0]
+	for _, names := range lists[1:] {
+		candidates = intersect(candidates, names)
+	}
+	return candidates, nil
+}
+
+func pick(candidates []string, seed string) string {
+	if seed == "" {
+		return candidates[rand.IntN(len(candidates))]
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(strings.TrimSpace(seed)))
+	return candidates[h.Sum64()%uint64(len(candidates))]
+}
+
+func (ps *PokemonService) GetRandomPokemon(ctx context.Context, opts types.RandomOptions, translate bool) (*types.GetPokemonResult, error) {
+	candidates, err := ps.getCandidates(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: no pokemon matches habitat [%s] and generation [%s]", types.ErrNotFound, opts.Habitat, opts.Generation)
+	}
+
+	// random picks are not searches, so they don't count towards popularity
+	return ps.getPokemonResult(ctx, pick(candidates, opts.Seed), translate, "")
+}
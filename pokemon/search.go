@@ -0,0 +1,49 @@
+package pokemon
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+	"golang.org/x/sync/errgroup"
+)
+
+const MaxQueryLength = 200
+
+func (ps *PokemonService) Search(query string, limit int) (*types.SearchResponse, error) {
+	query = strings.TrimSpace(query)
+	if query == "" || len(query) > MaxQueryLength {
+		return nil, types.InvalidInput("query must be between 1 and %d characters", MaxQueryLength)
+	}
+
+	return &types.SearchResponse{Query: query, Results: ps.search.Search(query, limit)}, nil
+}
+
+// IndexAllSpecies fetches every known species so that its description ends
+// up in the search index, instead of waiting for it to be looked up
+func (ps *PokemonService) IndexAllSpecies(ctx context.Context, concurrency int) error {
+	names, err := ps.getSpeciesNames(ctx, "species", ps.source.SpeciesNames)
+	if err != nil {
+		return err
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for _, name := range names {
+		g.Go(func() error {
+			_, err := ps.getPokemon(gctx, name)
+			if err != nil && !errors.Is(err, types.ErrNotFound) {
+				return err
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	slog.Info("indexed species descriptions", "species", len(names), "documents", ps.search.Len())
+	return nil
+}
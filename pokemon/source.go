@@ -0,0 +1,210 @@
+package pokemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+)
+
+const speciesIndexPath = "pokemon-species/?limit=100000"
+
+// SpeciesSource provides the PokéAPI resources that PokemonService needs.
+// Implementations return errors wrapping types.ErrNotFound for resources
+// that don't exist.
+type SpeciesSource interface {
+	Species(ctx context.Context, name string) (*APIPokemon, error)
+	Variety(ctx context.Context, name string) (*APIVariety, error)
+	Type(ctx context.Context, name string) (*APIType, error)
+	SpeciesNames(ctx context.Context) ([]string, error)
+	HabitatSpecies(ctx context.Context, habitat string) ([]string, error)
+	GenerationSpecies(ctx context.Context, generation string) ([]string, error)
+}
+
+type NamedAPIResourceList struct {
+	Results []NameAndURL `json:"results"`
+}
+
+// habitats and generations list their species in the same shape
+type SpeciesGroup struct {
+	PokemonSpecies []NameAndURL `json:"pokemon_species"`
+}
+
+// HTTPSource fetches resources from PokéAPI's REST API
+type HTTPSource struct {
+	baseURL *url.URL
+	client  *http.Client
+}
+
+func NewHTTPSource(baseURL string, client *http.Client) (*HTTPSource, error) {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HTTPSource{baseURL: parsed, client: client}, nil
+}
+
+func (src *HTTPSource) getResourceURL(path string) string {
+	rel, _ := url.Parse(path)
+	return src.baseURL.ResolveReference(rel).String()
+}
+
+func (src *HTTPSource) get(ctx context.Context, path string, out any) error {
+	url := src.getResourceURL(path)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("%w while creating req to retrieve %s from api: %v", types.ErrGeneric, url, err)
+	}
+	resp, err := src.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w while trying to retrieve %s from api: %v", types.ErrGeneric, url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w while requesting %s", types.ErrNotFound, path)
+	} else if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%w unexpected status %d from upstream while requesting %s: %s", types.ErrGeneric, resp.StatusCode, path, string(bodyBytes))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w while reading response body for %s: %v", types.ErrGeneric, path, err)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("%w while unmarshaling response for %s: %v", types.ErrGeneric, path, err)
+	}
+	return nil
+}
+
+func getResource[T any](ctx context.Context, src *HTTPSource, path string) (*T, error) {
+	var resource T
+	if err := src.get(ctx, path, &resource); err != nil {
+		return nil, err
+	}
+	return &resource, nil
+}
+
+func (src *HTTPSource) Species(ctx context.Context, name string) (*APIPokemon, error) {
+	return getResource[APIPokemon](ctx, src, "pokemon-species/"+name)
+}
+
+func (src *HTTPSource) Variety(ctx context.Context, name string) (*APIVariety, error) {
+	return getResource[APIVariety](ctx, src, "pokemon/"+name)
+}
+
+func (src *HTTPSource) Type(ctx context.Context, name string) (*APIType, error) {
+	return getResource[APIType](ctx, src, "type/"+name)
+}
+
+func (src *HTTPSource) SpeciesNames(ctx context.Context) ([]string, error) {
+	list, err := getResource[NamedAPIResourceList](ctx, src, speciesIndexPath)
+	if err != nil {
+		return nil, err
+	}
+	return namesOf(list.Results), nil
+}
+
+func (src *HTTPSource) HabitatSpecies(ctx context.Context, habitat string) ([]string, error) {
+	group, err := getResource[SpeciesGroup](ctx, src, "pokemon-habitat/"+habitat)
+	if err != nil {
+		return nil, err
+	}
+	return namesOf(group.PokemonSpecies), nil
+}
+
+func (src *HTTPSource) GenerationSpecies(ctx context.Context, generation string) ([]string, error) {
+	group, err := getResource[SpeciesGroup](ctx, src, "generation/"+generation)
+	if err != nil {
+		return nil, err
+	}
+	return namesOf(group.PokemonSpecies), nil
+}
+
+// FallbackSource asks its sources in order and returns the first successful
+// answer. When all of them fail, errors other than types.ErrNotFound take
+// precedence, so that an outage isn't reported as a missing pokemon.
+type FallbackSource struct {
+	sources []SpeciesSource
+}
+
+func NewFallbackSource(sources ...SpeciesSource) *FallbackSource {
+	return &FallbackSource{sources: sources}
+}
+
+type fallbackKey struct{}
+
+// withFallbackReport returns a context in which FallbackSource reports
+// answers that didn't come from its first source, e.g. the embedded dataset
+// answering while PokéAPI is down, which must not be cached
+func withFallbackReport(ctx context.Context) (context.Context, *atomic.Bool) {
+	var fellBack atomic.Bool
+	return context.WithValue(ctx, fallbackKey{}, &fellBack), &fellBack
+}
+
+func fallback[T any](ctx context.Context, fs *FallbackSource, get func(SpeciesSource) (T, error)) (T, error) {
+	var firstErr error
+	for i, src := range fs.sources {
+		value, err := get(src)
+		if err == nil {
+			if i > 0 && firstErr != nil && !errors.Is(firstErr, types.ErrNotFound) {
+				slog.Warn("species source failed, served by fallback", "source", fmt.Sprintf("%T", src), "error", firstErr)
+			}
+			if fellBack, ok := ctx.Value(fallbackKey{}).(*atomic.Bool); ok && i > 0 {
+				fellBack.Store(true)
+			}
+			return value, nil
+		}
+		if firstErr == nil || (errors.Is(firstErr, types.ErrNotFound) && !errors.Is(err, types.ErrNotFound)) {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	var zero T
+	if firstErr == nil {
+		firstErr = fmt.Errorf("%w: no species source configured", types.ErrGeneric)
+	}
+	return zero, firstErr
+}
+
+func (fs *FallbackSource) Species(ctx context.Context, name string) (*APIPokemon, error) {
+	return fallback(ctx, fs, func(src SpeciesSource) (*APIPokemon, error) { return src.Species(ctx, name) })
+}
+
+func (fs *FallbackSource) Variety(ctx context.Context, name string) (*APIVariety, error) {
+	return fallback(ctx, fs, func(src SpeciesSource) (*APIVariety, error) { return src.Variety(ctx, name) })
+}
+
+func (fs *FallbackSource) Type(ctx context.Context, name string) (*APIType, error) {
+	return fallback(ctx, fs, func(src SpeciesSource) (*APIType, error) { return src.Type(ctx, name) })
+}
+
+func (fs *FallbackSource) SpeciesNames(ctx context.Context) ([]string, error) {
+	return fallback(ctx, fs, func(src SpeciesSource) ([]string, error) { return src.SpeciesNames(ctx) })
+}
+
+func (fs *FallbackSource) HabitatSpecies(ctx context.Context, habitat string) ([]string, error) {
+	return fallback(ctx, fs, func(src SpeciesSource) ([]string, error) { return src.HabitatSpecies(ctx, habitat) })
+}
+
+func (fs *FallbackSource) GenerationSpecies(ctx context.Context, generation string) ([]string, error) {
+	return fallback(ctx, fs, func(src SpeciesSource) ([]string, error) { return src.GenerationSpecies(ctx, generation) })
+}
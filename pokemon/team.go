@@ -0,0 +1,152 @@
+package pokemon
+
+import (
+	"cmp"
+	"context"
+	"slices"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+	"golang.org/x/sync/errgroup"
+)
+
+const MaxTeamSize = 6
+
+func (ps *PokemonService) loadAllTypes(ctx context.Context) error {
+	g, gctx := errgroup.WithContext(ctx)
+	for _, t := range BattleTypes {
+		g.Go(func() error {
+			_, err := ps.getDefenseMultipliers(gctx, t)
+			return err
+		})
+	}
+	return g.Wait()
+}
+
+func teamTypes(members []*types.PokemonDetails) []string {
+	var result []string
+	for _, m := range members {
+		for _, t := range m.Variety.Types {
+			if !slices.Contains(result, t) {
+				result = append(result, t)
+			}
+		}
+	}
+	return result
+}
+
+func (ps *PokemonService) getCoverage(ctx context.Context, attackers []string) (covered, uncovered []string, err error) {
+	covered, uncovered = []string{}, []string{}
+	for _, defender := range BattleTypes {
+		isCovered := false
+		for _, attacker := range attackers {
+			m, err := ps.multiplier(ctx, attacker, []string{defender})
+			if err != nil {
+				return nil, nil, err
+			}
+			if m > 1 {
+				isCovered = true
+				break
+			}
+		}
+		if isCovered {
+			covered = append(covered, defender)
+		} else {
+			uncovered = append(uncovered, defender)
+		}
+	}
+	return covered, uncovered, nil
+}
+
+func getVulnerabilities(members []*types.PokemonDetails) (shared map[string][]string, vulnerabilities []types.TeamVulnerability) {
+	shared = make(map[string][]string)
+	vulnerabilities = []types.TeamVulnerability{}
+	for _, attacker := range BattleTypes {
+		v := types.TeamVulnerability{Type: attacker, Weak: []string{}, Resistant: []string{}}
+		for _, m := range members {
+			if _, weak := m.Defense.Weaknesses[attacker]; weak {
+				v.Weak = append(v.Weak, m.Pokemon.Name)
+			} else if _, resists := m.Defense.Resistances[attacker]; resists || slices.Contains(m.Defense.Immunities, attacker) {
+				v.Resistant = append(v.Resistant, m.Pokemon.Name)
+			}
+		}
+
+		if len(v.Weak) > 1 {
+			shared[attacker] = v.Weak
+		}
+		v.Score = len(v.Weak) - len(v.Resistant)
+		if v.Score > 0 {
+			vulnerabilities = append(vulnerabilities, v)
+		}
+	}
+
+	slices.SortStableFunc(vulnerabilities, func(a, b types.TeamVulnerability) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(len(b.Weak), len(a.Weak)))
+	})
+	return shared, vulnerabilities
+}
+
+func getTeamStats(members []*types.PokemonDetails) types.TeamStats {
+	stats := types.TeamStats{Totals: []types.Stat{}, Members: make(map[string]int)}
+	for _, m := range members {
+		for _, s := range m.Variety.Stats {
+			i := slices.IndexFunc(stats.Totals, func(total types.Stat) bool { return total.Name == s.Name })
+			if i == -1 {
+				stats.Totals = append(stats.Totals, types.Stat{Name: s.Name})
+				i = len(stats.Totals) - 1
+			}
+			stats.Totals[i].Base += s.Base
+			stats.Members[m.Pokemon.Name] += s.Base
+			stats.Total += s.Base
+		}
+	}
+	return stats
+}
+
+func (ps *PokemonService) AnalyzeTeam(ctx context.Context, names []string) (*types.TeamAnalysis, error) {
+	if len(names) == 0 || len(names) > MaxTeamSize {
+		return nil, types.InvalidInput("a team has between 1 and %d members, got %d", MaxTeamSize, len(names))
+	}
+
+	normalized := make([]string, 0, len(names))
+	for i, name := range names {
+		name = ps.names.Canonicalize(name)
+		if name == "" {
+			return nil, types.InvalidInput("team member %d has an empty name", i+1)
+		}
+		if slices.Contains(normalized, name) {
+			return nil, types.InvalidInput("team member %d repeats [%s]", i+1, name)
+		}
+		normalized = append(normalized, name)
+	}
+
+	members := make([]*types.PokemonDetails, len(normalized))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, name := range normalized {
+		g.Go(func() error {
+			details, err := ps.getDetails(gctx, name)
+			members[i] = details
+			return err
+		})
+	}
+	g.Go(func() error {
+		return ps.loadAllTypes(gctx)
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	coverage, uncovered, err := ps.getCoverage(ctx, teamTypes(members))
+	if err != nil {
+		return nil, err
+	}
+	shared, vulnerabilities := getVulnerabilities(members)
+
+	return &types.TeamAnalysis{
+		Members:          members,
+		Coverage:         coverage,
+		UncoveredTypes:   uncovered,
+		SharedWeaknesses: shared,
+		Vulnerabilities:  vulnerabilities,
+		Stats:            getTeamStats(members),
+	}, nil
+}
@@ -0,0 +1,204 @@
+package popularity
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+	"github.com/sbaglivi/TL-Pokedex/utils"
+)
+
+const (
+	bucketSize = time.Hour
+	Retention  = 7 * 24 * time.Hour
+)
+
+// counts are kept in hourly buckets so that "trending" windows can be summed
+// from them, while the total survives bucket expiration
+type counter struct {
+	Total   int64           `json:"total"`
+	Buckets map[int64]int64 `json:"buckets"`
+}
+
+type Tracker struct {
+	mu       sync.Mutex
+	counters map[string]*counter
+	now      func() time.Time
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		counters: make(map[string]*counter),
+		now:      time.Now,
+	}
+}
+
+func bucketOf(t time.Time) int64 {
+	return t.Unix() / int64(bucketSize/time.Second)
+}
+
+func (c *counter) prune(oldest int64) {
+	for b := range c.Buckets {
+		if b < oldest {
+			delete(c.Buckets, b)
+		}
+	}
+}
+
+func (t *Tracker) Record(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, exists := t.counters[name]
+	if !exists {
+		c = &counter{Buckets: make(map[int64]int64)}
+		t.counters[name] = c
+	}
+
+	now := t.now()
+	c.Total++
+	c.Buckets[bucketOf(now)]++
+	c.prune(bucketOf(now.Add(-Retention)))
+}
+
+// Top returns the most searched pokemon in the given window, a window of 0
+// means all time
+func (t *Tracker) Top(window time.Duration, limit int) []types.PopularPokemon {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	oldest := bucketOf(t.now().Add(-window))
+	result := []types.PopularPokemon{}
+	for name, c := range t.counters {
+		count := c.Total
+		if window > 0 {
+			count = 0
+			for b, n := range c.Buckets {
+				if b >= oldest {
+					count += n
+				}
+			}
+		}
+		if count > 0 {
+			result = append(result, types.PopularPokemon{Name: name, Count: count})
+		}
+	}
+
+	slices.SortFunc(result, func(a, b types.PopularPokemon) int {
+		if a.Count != b.Count {
+			return cmp.Compare(b.Count, a.Count)
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// IsTop reports whether name is among the n most searched pokemon in window
+func (t *Tracker) IsTop(name string, window time.Duration, n int) bool {
+	return slices.ContainsFunc(t.Top(window, n), func(p types.PopularPokemon) bool {
+		return p.Name == name
+	})
+}
+
+// ParseWindow accepts "all", go durations ("90m", "24h") and days ("7d")
+func ParseWindow(s string) (time.Duration, error) {
+	if s == "" || s == "all" {
+		return 0, nil
+	}
+
+	var window time.Duration
+	if days, found := strings.CutSuffix(s, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, types.InvalidInput("cannot parse window [%s]", s)
+		}
+		window = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		window, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, types.InvalidInput("cannot parse window [%s]", s)
+		}
+	}
+
+	if window <= 0 || window > Retention {
+		return 0, types.InvalidInput("window [%s] must be positive and at most %s", s, Retention)
+	}
+	return window, nil
+}
+
+func (t *Tracker) Save(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	oldest := bucketOf(t.now().Add(-Retention))
+	for _, c := range t.counters {
+		c.prune(oldest)
+	}
+	return json.NewEncoder(w).Encode(t.counters)
+}
+
+func (t *Tracker) Load(r io.Reader) error {
+	counters := make(map[string]*counter)
+	if err := json.NewDecoder(r).Decode(&counters); err != nil {
+		return fmt.Errorf("while decoding popularity counters: %w", err)
+	}
+	for _, c := range counters {
+		if c.Buckets == nil {
+			c.Buckets = make(map[int64]int64)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counters = counters
+	return nil
+}
+
+func (t *Tracker) SaveFile(path string) error {
+	return utils.WriteFileAtomic(path, t.Save)
+}
+
+// LoadFile restores counters from path, a missing file is not an error
+func (t *Tracker) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("while opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	return t.Load(f)
+}
+
+func (t *Tracker) PersistEvery(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.SaveFile(path); err != nil {
+				slog.Error("failed to persist popularity counters", "path", path, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package popularity
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopWindows(t *testing.T) {
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	tracker := NewTracker()
+	tracker.now = func() time.Time { return now.Add(-48 * time.Hour) }
+	for i := 0; i < 5; i++ {
+		tracker.Record("pikachu")
+	}
+	tracker.now = func() time.Time { return now }
+	tracker.Record("mew")
+	tracker.Record("mew")
+	tracker.Record("pikachu")
+
+	assert.Equal(t, []types.PopularPokemon{{Name: "pikachu", Count: 6}, {Name: "mew", Count: 2}}, tracker.Top(0, 10))
+	assert.Equal(t, []types.PopularPokemon{{Name: "mew", Count: 2}, {Name: "pikachu", Count: 1}}, tracker.Top(24*time.Hour, 10))
+	assert.Equal(t, []types.PopularPokemon{{Name: "mew", Count: 2}}, tracker.Top(24*time.Hour, 1))
+	assert.True(t, tracker.IsTop("mew", 24*time.Hour, 1))
+	assert.False(t, tracker.IsTop("pikachu", 24*time.Hour, 1))
+}
+
+func TestBucketsExpireButTotalsSurvive(t *testing.T) {
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	tracker := NewTracker()
+	tracker.now = func() time.Time { return now.Add(-2 * Retention) }
+	tracker.Record("onix")
+	tracker.now = func() time.Time { return now }
+	tracker.Record("onix")
+
+	assert.Len(t, tracker.counters["onix"].Buckets, 1)
+	assert.Equal(t, []types.PopularPokemon{{Name: "onix", Count: 2}}, tracker.Top(0, 10))
+	assert.Equal(t, []types.PopularPokemon{{Name: "onix", Count: 1}}, tracker.Top(Retention, 10))
+}
+
+func TestParseWindow(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":    0,
+		"all": 0,
+		"24h": 24 * time.Hour,
+		"90m": 90 * time.Minute,
+		"7d":  7 * 24 * time.Hour,
+	}
+	for input, expected := range cases {
+		got, err := ParseWindow(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, got, input)
+	}
+
+	for _, input := range []string{"yesterday", "-1h", "30d"} {
+		_, err := ParseWindow(input)
+		if !errors.Is(err, types.ErrInvalidInput) {
+			t.Errorf("ParseWindow(%q) expected ErrInvalidInput, got %v", input, err)
+		}
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	tracker := NewTracker()
+	tracker.Record("pikachu")
+	tracker.Record("pikachu")
+	tracker.Record("mew")
+
+	var buf bytes.Buffer
+	if err := tracker.Save(&buf); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	restored := NewTracker()
+	if err := restored.Load(&buf); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	assert.Equal(t, tracker.Top(0, 10), restored.Top(0, 10))
+	assert.Equal(t, tracker.Top(time.Hour, 10), restored.Top(time.Hour, 10))
+
+	path := filepath.Join(t.TempDir(), "popularity.json")
+	if err := restored.LoadFile(path); err != nil {
+		t.Fatalf("loading a missing file should not fail: %v", err)
+	}
+	if err := tracker.SaveFile(path); err != nil {
+		t.Fatalf("save file failed: %v", err)
+	}
+	fromFile := NewTracker()
+	if err := fromFile.LoadFile(path); err != nil {
+		t.Fatalf("load file failed: %v", err)
+	}
+	assert.Equal(t, tracker.Top(0, 10), fromFile.Top(0, 10))
+}
@@ -0,0 +1,200 @@
+package rules
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"slices"
+	"sync/atomic"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+)
+
+// Condition matches a pokemon when all of its set fields match. Lists match
+// when the pokemon has any of their values.
+type Condition struct {
+	Habitat     []string `json:"habitat,omitempty"`
+	Legendary   *bool    `json:"legendary,omitempty"`
+	Mythical    *bool    `json:"mythical,omitempty"`
+	Type        []string `json:"type,omitempty"`
+	Generation  []string `json:"generation,omitempty"`
+	NamePattern string   `json:"name_pattern,omitempty"`
+
+	name *regexp.Regexp
+}
+
+type Rule struct {
+	Name  string            `json:"name,omitempty"`
+	When  Condition         `json:"when"`
+	Style types.Translation `json:"style"`
+}
+
+// RuleSet picks the style of the first matching rule, or Default
+type RuleSet struct {
+	Rules   []Rule            `json:"rules"`
+	Default types.Translation `json:"default"`
+}
+
+// Subject is what rules are evaluated against
+type Subject struct {
+	Pokemon *types.Pokemon
+	// battle types, only filled in when the rules need them
+	Types []string
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// Default reproduces the original behavior: Yoda for legendary pokemon and
+// cave dwellers, Shakespeare for everyone else
+var Default = RuleSet{
+	Rules: []Rule{
+		{Name: "cave", When: Condition{Habitat: []string{"cave"}}, Style: types.Yoda},
+		{Name: "legendary", When: Condition{Legendary: boolPtr(true)}, Style: types.Yoda},
+	},
+	Default: types.Shakespeare,
+}
+
+func (c *Condition) matches(s Subject) bool {
+	pkmn := s.Pokemon
+	switch {
+	case len(c.Habitat) > 0 && !slices.Contains(c.Habitat, pkmn.Habitat):
+		return false
+	case c.Legendary != nil && *c.Legendary != pkmn.IsLegendary:
+		return false
+	case c.Mythical != nil && *c.Mythical != pkmn.IsMythical:
+		return false
+	case len(c.Type) > 0 && !slices.ContainsFunc(s.Types, func(t string) bool { return slices.Contains(c.Type, t) }):
+		return false
+	case len(c.Generation) > 0 && !slices.Contains(c.Generation, pkmn.Generation):
+		return false
+	case c.name != nil && !c.name.MatchString(pkmn.Name):
+		return false
+	}
+	return true
+}
+
+func (rs *RuleSet) Pick(s Subject) types.Translation {
+	for _, rule := range rs.Rules {
+		if rule.When.matches(s) {
+			return rule.Style
+		}
+	}
+	return rs.Default
+}
+
+// NeedsTypes reports whether any rule looks at battle types, which are
+// fetched separately from the species
+func (rs *RuleSet) NeedsTypes() bool {
+	return slices.ContainsFunc(rs.Rules, func(r Rule) bool { return len(r.When.Type) > 0 })
+}
+
+// within says where in the rules err was found, keeping the message for
+// clients of an InputError
+func within(where string, err error) error {
+	var input *types.InputError
+	if errors.As(err, &input) {
+		return types.InvalidInput("%s: %s", where, input.Message)
+	}
+	return fmt.Errorf("%s: %w", where, err)
+}
+
+// compile checks the rule set, compiling name patterns and checking styles
+// with validStyle
+func (rs *RuleSet) compile(validStyle func(types.Translation) error) error {
+	if rs.Default == "" {
+		return types.InvalidInput("a default style is required")
+	}
+	if err := validStyle(rs.Default); err != nil {
+		return within("default style", err)
+	}
+
+	for i := range rs.Rules {
+		rule := &rs.Rules[i]
+		label := cmp.Or(rule.Name, fmt.Sprintf("#%d", i+1))
+		if err := validStyle(rule.Style); err != nil {
+			return within("rule "+label, err)
+		}
+		if rule.When.NamePattern != "" {
+			re, err := regexp.Compile(rule.When.NamePattern)
+			if err != nil {
+				return types.InvalidInput("rule %s has an invalid name pattern: %v", label, err)
+			}
+			rule.When.name = re
+		}
+	}
+	return nil
+}
+
+func Parse(r io.Reader, validStyle func(types.Translation) error) (*RuleSet, error) {
+	var rs RuleSet
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&rs); err != nil {
+		return nil, types.InvalidInput("cannot decode rules: %v", err)
+	}
+	if err := rs.compile(validStyle); err != nil {
+		return nil, err
+	}
+	return &rs, nil
+}
+
+// Engine holds the rule set in use, which can be reloaded from its file
+// while requests are being served
+type Engine struct {
+	path       string
+	validStyle func(types.Translation) error
+	current    atomic.Pointer[RuleSet]
+}
+
+// NewEngine loads the rules in path, or uses Default when path is empty.
+// Either way the styles must pass validStyle.
+func NewEngine(path string, validStyle func(types.Translation) error) (*Engine, error) {
+	e := Engine{path: path, validStyle: validStyle}
+	if path == "" {
+		rs := RuleSet{Rules: slices.Clone(Default.Rules), Default: Default.Default}
+		if err := rs.compile(validStyle); err != nil {
+			return nil, fmt.Errorf("built-in rules: %w", err)
+		}
+		e.current.Store(&rs)
+		return &e, nil
+	}
+
+	if _, err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (e *Engine) Rules() *RuleSet {
+	return e.current.Load()
+}
+
+// Reload replaces the rules with the content of the file, keeping the
+// current ones if it is invalid. It returns the number of rules loaded.
+func (e *Engine) Reload() (int, error) {
+	if e.path == "" {
+		return 0, types.InvalidInput("no rules file configured")
+	}
+
+	f, err := os.Open(e.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("while opening rules file %s: %w", e.path, types.InvalidInput("the rules file does not exist"))
+	} else if err != nil {
+		return 0, fmt.Errorf("while opening rules file %s: %w", e.path, err)
+	}
+	defer f.Close()
+
+	rs, err := Parse(f, e.validStyle)
+	if err != nil {
+		return 0, fmt.Errorf("while loading %s: %w", e.path, err)
+	}
+	e.current.Store(rs)
+	return len(rs.Rules), nil
+}
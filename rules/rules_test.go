@@ -0,0 +1,135 @@
+package rules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+	"github.com/stretchr/testify/assert"
+)
+
+var known = []types.Translation{types.Yoda, types.Shakespeare, types.Pirate, types.Sith, types.Minion, types.PigLatin}
+
+func validStyle(style types.Translation) error {
+	if !slices.Contains(known, style) {
+		return fmt.Errorf("%w: unknown translation style [%s]", types.ErrInvalidInput, style)
+	}
+	return nil
+}
+
+func TestDefaultRules(t *testing.T) {
+	cases := []struct {
+		pokemon  types.Pokemon
+		expected types.Translation
+	}{
+		{types.Pokemon{Name: "zubat", Habitat: "cave"}, types.Yoda},
+		{types.Pokemon{Name: "mewtwo", Habitat: "rare", IsLegendary: true}, types.Yoda},
+		{types.Pokemon{Name: "pikachu", Habitat: "forest"}, types.Shakespeare},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, Default.Pick(Subject{Pokemon: &c.pokemon}), c.pokemon.Name)
+	}
+	assert.False(t, Default.NeedsTypes())
+}
+
+func TestExampleRules(t *testing.T) {
+	f, err := os.Open("../translation-rules.example.json")
+	if err != nil {
+		t.Fatalf("opening example rules: %v", err)
+	}
+	defer f.Close()
+	rs, err := Parse(f, validStyle)
+	if err != nil {
+		t.Fatalf("parsing example rules: %v", err)
+	}
+	assert.True(t, rs.NeedsTypes())
+
+	cases := []struct {
+		subject  Subject
+		expected types.Translation
+	}{
+		{Subject{Pokemon: &types.Pokemon{Name: "mew", IsMythical: true}}, types.Sith},
+		{Subject{Pokemon: &types.Pokemon{Name: "mewtwo", IsLegendary: true}}, types.Yoda},
+		{Subject{Pokemon: &types.Pokemon{Name: "squirtle"}, Types: []string{"water"}}, types.Pirate},
+		{Subject{Pokemon: &types.Pokemon{Name: "mr-mime"}, Types: []string{"psychic", "fairy"}}, types.Minion},
+		{Subject{Pokemon: &types.Pokemon{Name: "pikachu", Habitat: "forest", Generation: "generation-i"}}, types.PigLatin},
+		{Subject{Pokemon: &types.Pokemon{Name: "treecko", Habitat: "forest", Generation: "generation-iii"}}, types.Shakespeare},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, rs.Pick(c.subject), c.subject.Pokemon.Name)
+	}
+}
+
+func TestParseRejectsInvalidRules(t *testing.T) {
+	cases := map[string]string{
+		"unknown style":      `{"rules": [{"when": {"legendary": true}, "style": "elvish"}], "default": "yoda"}`,
+		"unknown default":    `{"rules": [], "default": "elvish"}`,
+		"missing default":    `{"rules": []}`,
+		"invalid pattern":    `{"rules": [{"when": {"name_pattern": "("}, "style": "yoda"}], "default": "yoda"}`,
+		"unknown condition":  `{"rules": [{"when": {"colour": "red"}, "style": "yoda"}], "default": "yoda"}`,
+		"malformed document": `{"rules": `,
+	}
+	for name, doc := range cases {
+		_, err := Parse(strings.NewReader(doc), validStyle)
+		assert.ErrorIs(t, err, types.ErrInvalidInput, name)
+	}
+}
+
+func TestEngineReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	write := func(doc string) {
+		if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+			t.Fatalf("writing rules: %v", err)
+		}
+	}
+	onix := Subject{Pokemon: &types.Pokemon{Name: "onix", Habitat: "cave"}}
+
+	write(`{"rules": [{"when": {"habitat": ["cave"]}, "style": "pirate"}], "default": "yoda"}`)
+	engine, err := NewEngine(path, validStyle)
+	if err != nil {
+		t.Fatalf("creating engine: %v", err)
+	}
+	assert.Equal(t, types.Pirate, engine.Rules().Pick(onix))
+
+	write(`{"rules": [{"when": {"habitat": ["cave"]}, "style": "elvish"}], "default": "yoda"}`)
+	_, err = engine.Reload()
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+	assert.Equal(t, types.Pirate, engine.Rules().Pick(onix), "invalid rules must not replace the current ones")
+
+	write(`{"rules": [], "default": "sith"}`)
+	n, err := engine.Reload()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, types.Sith, engine.Rules().Pick(onix))
+
+	engine, err = NewEngine("", validStyle)
+	assert.NoError(t, err)
+	assert.Equal(t, &Default, engine.Rules())
+	_, err = engine.Reload()
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+}
+
+func TestEngineDefaultNeedsItsStyles(t *testing.T) {
+	onlyPirate := func(style types.Translation) error {
+		if style != types.Pirate {
+			return fmt.Errorf("%w: unknown translation style [%s]", types.ErrInvalidInput, style)
+		}
+		return nil
+	}
+
+	_, err := NewEngine("", onlyPirate)
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+	assert.ErrorContains(t, err, "built-in rules")
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(`{"rules": [], "default": "pirate"}`), 0o600); err != nil {
+		t.Fatalf("writing rules: %v", err)
+	}
+	engine, err := NewEngine(path, onlyPirate)
+	assert.NoError(t, err)
+	assert.Equal(t, types.Pirate, engine.Rules().Default)
+}
@@ -0,0 +1,224 @@
+package search
+
+import (
+	"cmp"
+	"html"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+)
+
+const snippetRadius = 6
+
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "has": true, "in": true, "is": true, "it": true, "its": true, "of": true,
+	"on": true, "or": true, "that": true, "the": true, "this": true, "to": true, "with": true,
+}
+
+type document struct {
+	name  string
+	field string
+	text  string
+	terms map[string]int
+}
+
+// Index is an in-memory inverted index over pokemon texts, every pokemon can
+// have several fields (e.g. its description and its translations)
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[string]*document
+	postings map[string]map[string]int
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string]int),
+	}
+}
+
+func stem(word string) string {
+	if len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") {
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+func words(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
+
+// normalizeWord returns the term for word, or "" if it's a stopword
+func normalizeWord(word string) string {
+	word = strings.Trim(strings.ToLower(word), "'")
+	if stopwords[word] {
+		return ""
+	}
+	return stem(word)
+}
+
+// Tokenize splits s in lowercase, stemmed terms without stopwords
+func Tokenize(s string) []string {
+	var tokens []string
+	for _, w := range words(s) {
+		if term := normalizeWord(w); term != "" {
+			tokens = append(tokens, term)
+		}
+	}
+	return tokens
+}
+
+func docKey(name, field string) string {
+	return name + "\x00" + field
+}
+
+func (idx *Index) remove(key string) {
+	doc, exists := idx.docs[key]
+	if !exists {
+		return
+	}
+	for term := range doc.terms {
+		delete(idx.postings[term], key)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.docs, key)
+}
+
+// Add indexes text as the given field of a pokemon, replacing what was
+// previously indexed for the same field
+func (idx *Index) Add(name, field, text string) {
+	key := docKey(name, field)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if doc, exists := idx.docs[key]; exists && doc.text == text {
+		return
+	}
+	idx.remove(key)
+	if text == "" {
+		return
+	}
+
+	doc := &document{name: name, field: field, text: text, terms: make(map[string]int)}
+	for _, term := range Tokenize(text) {
+		doc.terms[term]++
+	}
+	for term, tf := range doc.terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[string]int)
+		}
+		idx.postings[term][key] = tf
+	}
+	idx.docs[key] = doc
+}
+
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+func containsPhrase(text string, terms []string) bool {
+	tokens := Tokenize(text)
+	for i := range tokens {
+		if i+len(terms) <= len(tokens) && slices.Equal(tokens[i:i+len(terms)], terms) {
+			return true
+		}
+	}
+	return false
+}
+
+// snippet returns the words around the first match, HTML escaped, with every
+// matching word wrapped in <em> tags
+func snippet(text string, terms []string) string {
+	fields := strings.Fields(text)
+	first := 0
+	matched := false
+	highlighted := make([]string, len(fields))
+	for i, f := range fields {
+		highlighted[i] = html.EscapeString(f)
+		for _, w := range words(f) {
+			if slices.Contains(terms, normalizeWord(w)) {
+				before, after, _ := strings.Cut(f, w)
+				highlighted[i] = html.EscapeString(before) + "<em>" + html.EscapeString(w) + "</em>" + html.EscapeString(after)
+				if first == 0 && !matched {
+					first = i
+				}
+				matched = true
+				break
+			}
+		}
+	}
+
+	start, end := max(first-snippetRadius, 0), min(first+snippetRadius+1, len(fields))
+	result := strings.Join(highlighted[start:end], " ")
+	if start > 0 {
+		result = "…" + result
+	}
+	if end < len(fields) {
+		result += "…"
+	}
+	return result
+}
+
+// Search ranks pokemon by the tf-idf score of the query terms, boosting
+// documents that contain all of them and those that contain them as a phrase.
+// Only the best matching field is reported for each pokemon.
+func (idx *Index) Search(query string, limit int) []types.SearchResult {
+	terms := Tokenize(query)
+	results := []types.SearchResult{}
+	if len(terms) == 0 {
+		return results
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	unique := slices.Compact(slices.Sorted(slices.Values(terms)))
+	scores := make(map[string]float64)
+	matched := make(map[string]int)
+	for _, term := range unique {
+		postings := idx.postings[term]
+		idf := math.Log(1 + float64(len(idx.docs))/float64(1+len(postings)))
+		for key, tf := range postings {
+			doc := idx.docs[key]
+			scores[key] += float64(tf) / float64(len(doc.terms)) * idf
+			matched[key]++
+		}
+	}
+
+	best := make(map[string]types.SearchResult)
+	for key, score := range scores {
+		doc := idx.docs[key]
+		if matched[key] == len(unique) {
+			score *= 2
+			if len(terms) > 1 && containsPhrase(doc.text, terms) {
+				score *= 1.5
+			}
+		}
+		if current, exists := best[doc.name]; exists && current.Score >= score {
+			continue
+		}
+		best[doc.name] = types.SearchResult{Name: doc.name, Field: doc.field, Score: score, Snippet: snippet(doc.text, terms)}
+	}
+
+	for _, r := range best {
+		results = append(results, r)
+	}
+	slices.SortFunc(results, func(a, b types.SearchResult) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), strings.Compare(a.Name, b.Name))
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
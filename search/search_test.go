@@ -0,0 +1,62 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"sleep", "cave"}, Tokenize("Sleeps in the CAVES!"))
+	assert.Equal(t, []string{"farfetch'd", "grass"}, Tokenize("Farfetch'd: grass"))
+	assert.Empty(t, Tokenize("it is in the"))
+}
+
+func TestSearchRanking(t *testing.T) {
+	idx := NewIndex()
+	idx.Add("zubat", "description", "Forms colonies in perpetually dark places. It sleeps in caves during the day.")
+	idx.Add("onix", "description", "As it grows, the stone portions of its body harden. It burrows through caves.")
+	idx.Add("snorlax", "description", "Very lazy. Just eats and sleeps. As its rotund bulk builds, it becomes steadily more slothful.")
+	idx.Add("charmander", "description", "Obviously prefers hot places. When it rains, steam is said to spout from the tip of its tail.")
+
+	results := idx.Search("sleeps in caves", 10)
+	if assert.Len(t, results, 3) {
+		assert.Equal(t, "zubat", results[0].Name)
+		assert.ElementsMatch(t, []string{"onix", "snorlax"}, []string{results[1].Name, results[2].Name})
+	}
+	assert.Contains(t, results[0].Snippet, "<em>sleeps</em> in <em>caves</em>")
+
+	assert.Len(t, idx.Search("caves", 1), 1)
+	assert.Empty(t, idx.Search("the", 10))
+	assert.Empty(t, idx.Search("psychic", 10))
+}
+
+func TestAddReplacesField(t *testing.T) {
+	idx := NewIndex()
+	idx.Add("pikachu", "description", "It stores electricity in its cheeks.")
+	idx.Add("pikachu", "yoda", "Electricity in its cheeks, it stores.")
+	assert.Equal(t, 2, idx.Len())
+
+	results := idx.Search("electricity", 10)
+	assert.Len(t, results, 1, "fields of the same pokemon are merged")
+
+	idx.Add("pikachu", "description", "A mouse pokemon.")
+	results = idx.Search("mouse", 10)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "description", results[0].Field)
+	}
+	results = idx.Search("cheeks", 10)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "yoda", results[0].Field)
+	}
+}
+
+func TestSnippetIsTrimmed(t *testing.T) {
+	text := "one two three four five six seven eight nine ten eleven twelve thirteen fourteen fifteen"
+	assert.Equal(t, "…two three four five six seven <em>eight</em> nine ten eleven twelve thirteen fourteen…", snippet(text, []string{"eight"}))
+}
+
+func TestSnippetIsEscaped(t *testing.T) {
+	text := `It <script>alert("caves")</script> sleeps in caves & "dark" places.`
+	assert.Equal(t, `It &lt;script&gt;alert(&#34;<em>caves</em>&#34;)&lt;/script&gt; sleeps in <em>caves</em> &amp; &#34;dark&#34; places.`, snippet(text, []string{"cave"}))
+}
@@ -0,0 +1,90 @@
+package translate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+)
+
+type Translator interface {
+	Translate(context.Context, string, string, types.Translation) (*string, error)
+}
+
+// Provider is one of the translators tried by a Chain
+type Provider struct {
+	Name       string
+	Translator Translator
+	// how long the provider has to answer, no limit other than the caller's
+	// when zero
+	Timeout time.Duration
+	// Approximate providers, e.g. Local, are skipped for callers asking for
+	// exact translations with types.WithExactTranslation
+	Approximate bool
+}
+
+// Chain translates with the first of its providers that succeeds, so that a
+// provider that is rate limited, down or too slow is skipped transparently
+type Chain struct {
+	providers []Provider
+}
+
+func NewChain(providers ...Provider) *Chain {
+	return &Chain{providers: providers}
+}
+
+// skippable reports whether the next provider should be tried after err: the
+// caller's mistakes would be repeated by every provider, and once the caller
+// gave up there is no point in going on
+func skippable(ctx context.Context, err error) bool {
+	return !errors.Is(err, types.ErrInvalidInput) && ctx.Err() == nil
+}
+
+func (c *Chain) Translate(ctx context.Context, key, value string, translation types.Translation) (*string, error) {
+	translated, _, err := c.TranslateWithProvider(ctx, key, value, translation)
+	return translated, err
+}
+
+// TranslateWithProvider also returns the name of the provider that produced
+// the translation. When every provider fails the error of the first one is
+// returned, as the others are only fallbacks, wrapped in a
+// *types.ProviderError.
+func (c *Chain) TranslateWithProvider(ctx context.Context, key, value string, translation types.Translation) (*string, string, error) {
+	var first error
+	for _, provider := range c.providers {
+		if provider.Approximate && types.ExactTranslationFrom(ctx) {
+			continue
+		}
+		translated, err := provider.translate(ctx, key, value, translation)
+		if err == nil {
+			if first != nil {
+				slog.Info("translated with fallback provider", "key", key, "style", translation, "provider", provider.Name, "reason", first)
+			}
+			return translated, provider.Name, nil
+		}
+
+		if first == nil {
+			first = &types.ProviderError{Provider: provider.Name, Err: err}
+		}
+		if !skippable(ctx, err) {
+			return nil, "", first
+		}
+		slog.Debug("translation provider failed", "key", key, "style", translation, "provider", provider.Name, "error", err)
+	}
+	if first == nil {
+		first = fmt.Errorf("%w: no translation providers configured", types.ErrGeneric)
+	}
+	return nil, "", first
+}
+
+func (p *Provider) translate(ctx context.Context, key, value string, translation types.Translation) (*string, error) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	return p.Translator.Translate(ctx, key, value, translation)
+}
@@ -0,0 +1,177 @@
+package translate
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+	"github.com/sbaglivi/TL-Pokedex/utils"
+)
+
+var dictionaries = map[types.Translation]map[string]string{
+	types.Shakespeare: {
+		"you": "thee", "your": "thy", "yours": "thine", "yourself": "thyself",
+		"are": "art", "has": "hath", "does": "doth", "it's": "'tis",
+		"before": "ere", "often": "oft", "nothing": "naught", "perhaps": "perchance",
+		"maybe": "perchance", "over": "o'er", "never": "ne'er", "even": "e'en",
+		"between": "betwixt", "among": "amongst", "until": "till", "ever": "e'er",
+		"very": "most", "quickly": "swiftly", "enemy": "foe", "enemies": "foes",
+		"friend": "companion", "friends": "companions",
+	},
+	types.Pirate: {
+		"hello": "ahoy", "hi": "ahoy", "my": "me", "is": "be", "are": "be",
+		"you": "ye", "your": "yer", "friend": "matey", "friends": "mateys",
+		"yes": "aye", "the": "th'", "of": "o'", "stop": "avast", "treasure": "booty",
+		"money": "doubloons", "coins": "doubloons", "sea": "briny deep", "boy": "lad",
+		"girl": "lass", "people": "landlubbers", "enemy": "scallywag", "enemies": "scallywags",
+		"wow": "blimey", "food": "grub", "find": "come across",
+	},
+}
+
+// words after which "you" starts a clause, and so is a subject
+var conjunctions = []string{"and", "but", "or", "if", "when", "because", "that", "so", "while"}
+
+// subjects that start the sentences the Yoda reordering understands
+var yodaSubjects = []string{"it", "they", "he", "she", "this", "these", "its", "their", "i", "we", "you"}
+
+// verbs that bring the next word along when moved, as in "can fly"
+var auxiliaries = []string{
+	"is", "are", "was", "were", "can", "could", "will", "would", "may", "might",
+	"must", "should", "has", "have", "had", "does", "do", "did",
+}
+
+var (
+	words     = regexp.MustCompile(`[A-Za-z']+`)
+	sentences = regexp.MustCompile(`[^.!?]+[.!?]*`)
+)
+
+// Local translates without calling any API, with rough approximations of
+// some Funtranslations styles: Yoda reorders sentences, while Shakespeare
+// and Pirate substitute words from a dictionary
+type Local struct{}
+
+func NewLocal() *Local {
+	return &Local{}
+}
+
+func (l *Local) Supports(style types.Translation) bool {
+	_, found := dictionaries[style]
+	return found || style == types.Yoda
+}
+
+func (l *Local) Translate(ctx context.Context, key, value string, translation types.Translation) (*string, error) {
+	var translated string
+	switch {
+	case translation == types.Yoda:
+		translated = yodaReorder(value)
+	case translation == types.Shakespeare:
+		translated = substitute(thou(value), dictionaries[translation])
+	case l.Supports(translation):
+		translated = substitute(value, dictionaries[translation])
+	default:
+		return nil, fmt.Errorf("%w: style [%s] cannot be translated locally", types.ErrGeneric, translation)
+	}
+	translated = utils.RemoveWhitespace(translated)
+	return &translated, nil
+}
+
+func matchCase(original, replacement string) string {
+	first, _ := utf8.DecodeRuneInString(original)
+	if !unicode.IsUpper(first) {
+		return replacement
+	}
+	r, size := utf8.DecodeRuneInString(replacement)
+	return string(unicode.ToUpper(r)) + replacement[size:]
+}
+
+func substitute(text string, dictionary map[string]string) string {
+	return words.ReplaceAllStringFunc(text, func(word string) string {
+		replacement, found := dictionary[strings.ToLower(word)]
+		if !found {
+			return word
+		}
+		return matchCase(word, replacement)
+	})
+}
+
+// thou replaces "you" with "thou" where it is a subject, the dictionary
+// making it "thee" elsewhere: "You are kind if you can." becomes "Thou are
+// kind if thou can.", but "It follows you." is left alone.
+func thou(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range words.FindAllStringIndex(text, -1) {
+		word := text[match[0]:match[1]]
+		if !strings.EqualFold(word, "you") || !isSubject(text[:match[0]], text[match[1]:]) {
+			continue
+		}
+		b.WriteString(text[last:match[0]])
+		b.WriteString(matchCase(word, "thou"))
+		last = match[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// isSubject reports whether the word between before and after starts a
+// clause or is followed by a verb
+func isSubject(before, after string) bool {
+	before = strings.TrimSpace(before)
+	if before == "" || strings.ContainsAny(before[len(before)-1:], ".!?,;:") {
+		return true
+	}
+	if previous := words.FindAllString(before, -1); len(previous) > 0 && slices.Contains(conjunctions, strings.ToLower(previous[len(previous)-1])) {
+		return true
+	}
+	next := words.FindString(after)
+	return slices.Contains(auxiliaries, strings.ToLower(next)) || strings.EqualFold(next, "are")
+}
+
+// yodaReorder moves what follows the subject and verb of each sentence to
+// its front: "It stores electricity in its cheeks." becomes "Electricity in
+// its cheeks, it stores."
+func yodaReorder(text string) string {
+	var result []string
+	for _, sentence := range sentences.FindAllString(text, -1) {
+		result = append(result, yodaSentence(strings.TrimSpace(sentence)))
+	}
+	return strings.Join(result, " ")
+}
+
+func yodaSentence(sentence string) string {
+	body := strings.TrimRight(sentence, ".!?")
+	punctuation := sentence[len(body):]
+	fields := strings.Fields(body)
+	if len(fields) < 3 || !slices.Contains(yodaSubjects, strings.ToLower(fields[0])) {
+		return sentence
+	}
+
+	// possessive subjects take their noun along: "Its tail burns..."
+	subject := 1
+	if lower := strings.ToLower(fields[0]); lower == "its" || lower == "their" {
+		subject = 2
+	}
+	verb := subject + 1
+	if verb < len(fields) && slices.Contains(auxiliaries, strings.ToLower(fields[subject])) {
+		verb++
+	}
+	if verb >= len(fields) {
+		return sentence
+	}
+
+	head := strings.Join(fields[:verb], " ")
+	rest := strings.TrimRight(strings.Join(fields[verb:], " "), ",;")
+	if rest == "" {
+		return sentence
+	}
+	if fields[0] != "I" {
+		head = strings.ToLower(head[:1]) + head[1:]
+	}
+	return matchCase("X", rest) + ", " + head + cmp.Or(punctuation, ".")
+}
@@ -0,0 +1,135 @@
+package translate
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+)
+
+// Budget allows Limit calls per Period, Reserved of which only for high
+// priority requests
+type Budget struct {
+	Limit    int
+	Period   time.Duration
+	Reserved int
+}
+
+// Validate rejects budgets that could never be spent, whose refill rate
+// would be zero
+func (b Budget) Validate() error {
+	switch {
+	case b.Limit <= 0:
+		return fmt.Errorf("%w: budget limit must be positive, got %d", types.ErrInvalidInput, b.Limit)
+	case b.Period <= 0:
+		return fmt.Errorf("%w: budget period must be positive, got %s", types.ErrInvalidInput, b.Period)
+	case b.Reserved < 0 || b.Reserved >= b.Limit:
+		return fmt.Errorf("%w: reserved calls must be between 0 and %d, got %d", types.ErrInvalidInput, b.Limit-1, b.Reserved)
+	}
+	return nil
+}
+
+// bucket is a token bucket holding up to Limit tokens, refilled at a steady
+// rate so that Limit tokens are added every Period
+type bucket struct {
+	budget  Budget
+	tokens  float64
+	updated time.Time
+}
+
+func (b *bucket) refill(now time.Time) {
+	rate := float64(b.budget.Limit) / float64(b.budget.Period)
+	elapsed := max(0, now.Sub(b.updated))
+	b.tokens = math.Min(float64(b.budget.Limit), b.tokens+rate*float64(elapsed))
+	b.updated = now
+}
+
+// floor is the number of tokens a request with priority must leave
+func (b *bucket) floor(priority types.Priority) float64 {
+	if priority == types.HighPriority {
+		return 0
+	}
+	return float64(b.budget.Reserved)
+}
+
+// availableAt returns when the bucket will have a token for priority
+func (b *bucket) availableAt(now time.Time, priority types.Priority) time.Time {
+	missing := b.floor(priority) + 1 - b.tokens
+	// tolerate rounding errors from the refill
+	if missing <= 1e-9 {
+		return now
+	}
+	rate := float64(b.budget.Limit) / float64(b.budget.Period)
+	return now.Add(time.Duration(math.Ceil(missing / rate)))
+}
+
+// Quota spends translation API calls from all of its budgets, so that calls
+// that would be rejected upstream are never sent
+type Quota struct {
+	mu      sync.Mutex
+	buckets []*bucket
+	now     func() time.Time
+}
+
+func NewQuota(budgets ...Budget) *Quota {
+	q := Quota{now: time.Now}
+	now := q.now()
+	for _, budget := range budgets {
+		q.buckets = append(q.buckets, &bucket{budget: budget, tokens: float64(budget.Limit), updated: now})
+	}
+	return &q
+}
+
+// Reservation is a call taken from the quota, which must be either
+// committed once the call was sent or canceled to give it back
+type Reservation struct {
+	quota *Quota
+	done  bool
+}
+
+// Reserve takes a call from every budget, or returns a *types.RateLimitError
+// saying when one will be available
+func (q *Quota) Reserve(priority types.Priority) (*Reservation, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	retryAt := now
+	for _, b := range q.buckets {
+		b.refill(now)
+		if at := b.availableAt(now, priority); at.After(retryAt) {
+			retryAt = at
+		}
+	}
+	if retryAt.After(now) {
+		return nil, &types.RateLimitError{RetryAt: retryAt, Quota: true}
+	}
+
+	for _, b := range q.buckets {
+		b.tokens--
+	}
+	return &Reservation{quota: q}, nil
+}
+
+func (r *Reservation) Commit() {
+	r.done = true
+}
+
+func (r *Reservation) Cancel() {
+	if r.done {
+		return
+	}
+	r.done = true
+
+	q := r.quota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	for _, b := range q.buckets {
+		b.refill(now)
+		b.tokens = math.Min(float64(b.budget.Limit), b.tokens+1)
+	}
+}
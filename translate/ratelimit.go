@@ -0,0 +1,78 @@
+package translate
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// used when a 429 doesn't say how long to wait, or says a time already past
+const defaultRetryAfter = time.Minute
+
+// values above this are unix timestamps rather than a number of seconds
+const epochThreshold = 1_000_000_000
+
+// parseRetryAt reads Retry-After (seconds or an HTTP date) or, failing that,
+// X-RateLimit-Reset (seconds or a unix timestamp)
+func parseRetryAt(h http.Header, now time.Time) (time.Time, bool) {
+	if value := h.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds >= 0 {
+			return now.Add(time.Duration(seconds) * time.Second), true
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			return at, true
+		}
+	}
+
+	if value := h.Get("X-RateLimit-Reset"); value != "" {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+			if n > epochThreshold {
+				return time.Unix(n, 0), true
+			}
+			return now.Add(time.Duration(n) * time.Second), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// quotaExhausted reports whether a successful response says no more calls
+// are allowed until the reset
+func quotaExhausted(h http.Header) bool {
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	return err == nil && remaining <= 0
+}
+
+// holdUntil stops calls to the API until at, keeping the latest deadline
+func (ts *TranslationService) holdUntil(at time.Time) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if at.After(ts.notBefore) {
+		ts.notBefore = at
+	}
+}
+
+// heldUntil returns the time before which the API must not be called, or
+// the zero time if it can be called now
+func (ts *TranslationService) heldUntil() time.Time {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.now().Before(ts.notBefore) {
+		return ts.notBefore
+	}
+	return time.Time{}
+}
+
+func (ts *TranslationService) handleRateLimitHeaders(resp *http.Response) {
+	now := ts.now()
+	at, found := parseRetryAt(resp.Header, now)
+	// a reset already past, e.g. because of clock skew, tells nothing
+	found = found && at.After(now)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests && !found:
+		ts.holdUntil(now.Add(defaultRetryAfter))
+	case resp.StatusCode == http.StatusTooManyRequests || (found && quotaExhausted(resp.Header)):
+		ts.holdUntil(at)
+	}
+}
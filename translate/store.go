@@ -0,0 +1,197 @@
+package translate
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+)
+
+// Record is a translation together with where and when it was made
+type Record struct {
+	Text       string            `json:"text"`
+	Style      types.Translation `json:"style"`
+	Translated string            `json:"translated"`
+	Provider   string            `json:"provider"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+type recordKey struct {
+	text     string
+	style    types.Translation
+	provider string
+}
+
+func keyOf(record Record) recordKey {
+	return recordKey{record.Text, record.Style, record.Provider}
+}
+
+// Store keeps translations across restarts in a file of JSON lines, to which
+// new records are appended. Records are looked up by source text, style and
+// provider, later ones replacing earlier ones.
+type Store struct {
+	mu      sync.Mutex
+	file    *os.File
+	records map[recordKey]Record
+}
+
+// OpenStore loads the records in path, creating it if needed. Lines that
+// can't be decoded (e.g. one cut short by a crash) are skipped.
+func OpenStore(path string) (*Store, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("while opening translation store %s: %w", path, err)
+	}
+
+	s := Store{file: file, records: make(map[recordKey]Record)}
+	skipped, err := s.read(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("while reading translation store %s: %w", path, err)
+	}
+	if skipped > 0 {
+		slog.Warn("skipped invalid records in translation store", "path", path, "skipped", skipped)
+	}
+	if err := terminateLastLine(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("while repairing translation store %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// terminateLastLine appends a newline to file if it doesn't end with one,
+// so that records appended after a partial line aren't glued to it
+func terminateLastLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = file.Write([]byte{'\n'})
+	return err
+}
+
+// read loads the records in r, returning how many lines were skipped
+func (s *Store) read(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	skipped := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil || record.Text == "" || record.Style == "" {
+			skipped++
+			continue
+		}
+		s.records[keyOf(record)] = record
+	}
+	return skipped, scanner.Err()
+}
+
+func (s *Store) Get(text string, style types.Translation, provider string) (Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, found := s.records[recordKey{text, style, provider}]
+	return record, found
+}
+
+func (s *Store) Put(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("%w while serializing translation record: %v", types.ErrGeneric, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("while appending to translation store: %w", err)
+	}
+	s.records[keyOf(record)] = record
+	return nil
+}
+
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+func sortRecords(records []Record) {
+	slices.SortFunc(records, func(a, b Record) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.Text, b.Text), cmp.Compare(a.Style, b.Style))
+	})
+}
+
+// Export writes the current records, oldest first, in the store's format
+func (s *Store) Export(w io.Writer) error {
+	s.mu.Lock()
+	records := make([]Record, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	s.mu.Unlock()
+
+	sortRecords(records)
+	encoder := json.NewEncoder(w)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import adds the records read from r that the store doesn't have yet,
+// returning how many were added
+func (s *Store) Import(r io.Reader) (int, error) {
+	incoming := Store{records: make(map[recordKey]Record)}
+	skipped, err := incoming.read(r)
+	if err != nil {
+		return 0, err
+	}
+	if skipped > 0 {
+		return 0, fmt.Errorf("%w: %d records cannot be decoded", types.ErrInvalidInput, skipped)
+	}
+
+	records := make([]Record, 0, len(incoming.records))
+	for _, record := range incoming.records {
+		records = append(records, record)
+	}
+	sortRecords(records)
+
+	added := 0
+	for _, record := range records {
+		if _, found := s.Get(record.Text, record.Style, record.Provider); found {
+			continue
+		}
+		if err := s.Put(record); err != nil {
+			return added, err
+		}
+		added++
+	}
+	return added, nil
+}
+
+func (s *Store) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,87 @@
+package translate
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+)
+
+type Style struct {
+	Name types.Translation
+	// path of the Funtranslations endpoint, without the .json extension
+	Endpoint  string
+	Label     string
+	Available bool
+}
+
+var DefaultStyles = []Style{
+	{Name: types.Yoda, Endpoint: "yoda", Label: "Yoda", Available: true},
+	{Name: types.Shakespeare, Endpoint: "shakespeare", Label: "Shakespeare", Available: true},
+	{Name: types.Pirate, Endpoint: "pirate", Label: "Pirate", Available: true},
+	{Name: types.Minion, Endpoint: "minion", Label: "Minion", Available: true},
+	{Name: types.Klingon, Endpoint: "klingon", Label: "Klingon", Available: true},
+	{Name: types.PigLatin, Endpoint: "pig-latin", Label: "Pig Latin", Available: true},
+	{Name: types.Morse, Endpoint: "morse", Label: "Morse code", Available: true},
+	{Name: types.Sith, Endpoint: "sith", Label: "Sith", Available: true},
+	{Name: types.Gungan, Endpoint: "gungan", Label: "Gungan", Available: true},
+	{Name: types.Valyrian, Endpoint: "valyrian", Label: "High Valyrian", Available: true},
+	{Name: types.Dothraki, Endpoint: "dothraki", Label: "Dothraki", Available: true},
+	{Name: types.Cockney, Endpoint: "cockney", Label: "Cockney", Available: true},
+}
+
+// Registry holds the translation styles the service knows about, in the
+// order they are listed
+type Registry struct {
+	styles []Style
+}
+
+func NewRegistry(styles ...Style) *Registry {
+	return &Registry{styles: slices.Clone(styles)}
+}
+
+func (r *Registry) get(name types.Translation) (Style, bool) {
+	i := slices.IndexFunc(r.styles, func(s Style) bool { return s.Name == name })
+	if i < 0 {
+		return Style{}, false
+	}
+	return r.styles[i], true
+}
+
+// Lookup returns the style called name, failing with types.ErrInvalidInput
+// if it is unknown or unavailable
+func (r *Registry) Lookup(name types.Translation) (Style, error) {
+	style, found := r.get(name)
+	if !found {
+		return Style{}, types.InvalidInput("unknown translation style [%s]", name)
+	}
+	if !style.Available {
+		return Style{}, types.InvalidInput("translation style [%s] is not available", name)
+	}
+	return style, nil
+}
+
+// Restrict makes only the named styles available, an empty list keeps all of
+// them. Unknown names are an error.
+func (r *Registry) Restrict(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	for _, name := range names {
+		if _, found := r.get(types.Translation(name)); !found {
+			return fmt.Errorf("%w: unknown translation style [%s]", types.ErrInvalidInput, name)
+		}
+	}
+	for i := range r.styles {
+		r.styles[i].Available = slices.Contains(names, string(r.styles[i].Name))
+	}
+	return nil
+}
+
+func (r *Registry) Styles() []types.TranslationStyle {
+	styles := make([]types.TranslationStyle, 0, len(r.styles))
+	for _, s := range r.styles {
+		styles = append(styles, types.TranslationStyle{Name: s.Name, Label: s.Label, Available: s.Available})
+	}
+	return styles
+}
@@ -0,0 +1,72 @@
+package translate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+)
+
+// digest identifies text in logs and errors without including it, since
+// texts sent by users can contain anything
+func digest(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return fmt.Sprintf("text %s (%d chars)", hex.EncodeToString(sum[:6]), utf8.RuneCountInString(text))
+}
+
+type freeTextKey struct{}
+
+// asFreeText marks translations made with ctx as texts sent by users, which
+// are not looked up in or saved to the store, since it must not keep what
+// users send, and are cached apart from pokemon descriptions
+func asFreeText(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freeTextKey{}, true)
+}
+
+func isFreeText(ctx context.Context) bool {
+	free, _ := ctx.Value(freeTextKey{}).(bool)
+	return free
+}
+
+// noCache is used for free text when the service has no cache for it
+type noCache struct{}
+
+func (noCache) Get(string) (any, bool) { return nil, false }
+func (noCache) Put(string, any)        {}
+
+// TextTranslator translates free text through the same chain of providers as
+// pokemon descriptions, sharing their quota but not their cache or store
+type TextTranslator struct {
+	chain     *Chain
+	maxLength int
+}
+
+// NewTextTranslator accepts texts of up to maxLength characters
+func NewTextTranslator(chain *Chain, maxLength int) *TextTranslator {
+	return &TextTranslator{chain: chain, maxLength: maxLength}
+}
+
+func (tt *TextTranslator) TranslateText(ctx context.Context, text string, style types.Translation) (*types.TranslateTextResult, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "":
+		return nil, types.InvalidInput("text is required")
+	case utf8.RuneCountInString(text) > tt.maxLength:
+		return nil, types.InvalidInput("text must be at most %d characters long", tt.maxLength)
+	case style == "":
+		return nil, types.InvalidInput("style is required")
+	}
+
+	// the key only needs to tell texts apart in the cache
+	sum := sha256.Sum256([]byte(text))
+	key := "text:" + hex.EncodeToString(sum[:])
+	translated, provider, err := tt.chain.TranslateWithProvider(asFreeText(ctx), key, text, style)
+	if err != nil {
+		return nil, err
+	}
+	return &types.TranslateTextResult{Translated: *translated, Style: style, Engine: provider}, nil
+}
@@ -4,23 +4,39 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	"github.com/sbaglivi/TL-Pokedex/types"
+	"github.com/sbaglivi/TL-Pokedex/upstream"
 	"github.com/sbaglivi/TL-Pokedex/utils"
 	"golang.org/x/sync/singleflight"
 )
 
+const secretHeader = "X-Funtranslations-Api-Secret"
+
 type TranslationService struct {
 	cache                types.Cache
+	textCache            types.Cache
 	baseURL              *url.URL
 	client               *http.Client
 	group                singleflight.Group
 	translateWithAPIfunc func(context.Context, string, types.Translation) (*string, error)
+	now                  func() time.Time
+	quota                *Quota
+	secret               utils.Secret
+	styles               *Registry
+	store                *Store
+
+	// the API is not called before notBefore, after it rate limited us
+	mu        sync.Mutex
+	notBefore time.Time
 }
 
 type Total struct {
@@ -46,22 +62,74 @@ type TranslationErrorResponse struct {
 	Error TranslationError `json:"error"`
 }
 
-func NewTranslationService(cache types.Cache, baseURL string, client *http.Client) (*TranslationService, error) {
+type Option func(*TranslationService)
+
+// WithQuota makes the service spend every API call from quota, and not
+// call the API when it is exhausted
+func WithQuota(quota *Quota) Option {
+	return func(ts *TranslationService) {
+		ts.quota = quota
+	}
+}
+
+// WithStyles replaces the default registry of translation styles
+func WithStyles(styles *Registry) Option {
+	return func(ts *TranslationService) {
+		ts.styles = styles
+	}
+}
+
+// WithAPISecret authenticates requests with a paid subscription's secret
+func WithAPISecret(secret utils.Secret) Option {
+	return func(ts *TranslationService) {
+		ts.secret = secret
+	}
+}
+
+// WithStore looks translations up in store before calling the API, and
+// saves there the ones the API makes. Only records made by the same host are
+// used, so stores can be shared by services calling different APIs.
+func WithStore(store *Store) Option {
+	return func(ts *TranslationService) {
+		ts.store = store
+	}
+}
+
+// WithTextCache keeps translations of free text in textCache, so that users
+// sending many different texts can't evict the pokemon descriptions from the
+// main cache. Without it they are not cached.
+func WithTextCache(textCache types.Cache) Option {
+	return func(ts *TranslationService) {
+		ts.textCache = textCache
+	}
+}
+
+func NewTranslationService(cache types.Cache, baseURL string, client *http.Client, opts ...Option) (*TranslationService, error) {
 	parsed, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, err
 	}
 	svc := TranslationService{
-		cache:   cache,
-		baseURL: parsed,
-		client:  client,
+		cache:     cache,
+		textCache: noCache{},
+		baseURL:   parsed,
+		client:    client,
+		now:       time.Now,
+		styles:    NewRegistry(DefaultStyles...),
 	}
 	svc.translateWithAPIfunc = svc.translateWithAPI
+	for _, opt := range opts {
+		opt(&svc)
+	}
 	return &svc, nil
 }
 
 func (ts *TranslationService) toURL(tsl types.Translation) string {
-	rel, _ := url.Parse(string(tsl) + ".json")
+	endpoint := string(tsl)
+	if style, found := ts.styles.get(tsl); found {
+		endpoint = style.Endpoint
+	}
+	rel, _ := url.Parse(endpoint + ".json")
 	return ts.baseURL.ResolveReference(rel).String()
 }
 
@@ -69,7 +137,7 @@ func getErrorMessage(body []byte) string {
 	var errorResponse TranslationErrorResponse
 	err := json.Unmarshal(body, &errorResponse)
 	if err != nil {
-		return string(body[:1024])
+		return string(body[:min(len(body), 1024)])
 	}
 
 	return errorResponse.Error.Message
@@ -83,30 +151,61 @@ func (ts *TranslationService) groupedTranslateWithAPI(ctx context.Context, s str
 	})
 
 	if shared {
-		slog.Debug("shared translation API request", "key", key)
+		slog.Debug("shared translation API request", "text", digest(s), "style", translation)
 	}
 
 	return translated.(*string), err
 }
 
+// reserve takes a call from the quota, if the service has one
+func (ts *TranslationService) reserve(ctx context.Context) (*Reservation, error) {
+	if ts.quota == nil {
+		return &Reservation{done: true}, nil
+	}
+
+	reservation, err := ts.quota.Reserve(types.PriorityFrom(ctx))
+	if err != nil {
+		slog.Info("translation quota exhausted", "priority", types.PriorityFrom(ctx), "error", err)
+		return nil, err
+	}
+	return reservation, nil
+}
+
 func (ts *TranslationService) translateWithAPI(ctx context.Context, s string, translation types.Translation) (*string, error) {
 	url := ts.toURL(translation)
 	body := map[string]string{"text": s}
 	reqBody, err := json.Marshal(body)
 	if err != nil {
-		return nil, fmt.Errorf("%w while serializing [%s] for translation request: %v", types.ErrGeneric, s, err)
+		return nil, fmt.Errorf("%w while serializing %s for translation request: %v", types.ErrGeneric, digest(s), err)
 	}
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
 	if err != nil {
-		return nil, fmt.Errorf("%w while preparing POST for url %s to translate [%s]: %v", types.ErrGeneric, url, s, err)
+		return nil, fmt.Errorf("%w while preparing POST for url %s to translate %s: %v", types.ErrGeneric, url, digest(s), err)
+	}
+	if ts.secret != "" {
+		req.Header.Set(secretHeader, string(ts.secret))
 	}
 
-	resp, err := ts.client.Do(req)
+	reservation, err := ts.reserve(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("%w while making translation request of type %s for [%s]: %v", types.ErrGeneric, translation, s, err)
+		return nil, err
+	}
+	resp, err := ts.client.Do(req)
+	// a request that timed out or was canceled may still have been counted
+	// upstream, so only the ones that never left give the call back
+	if upstream.NotSent(err) {
+		reservation.Cancel()
+	} else {
+		reservation.Commit()
+	}
+	if errors.Is(err, upstream.ErrCircuitOpen) {
+		return nil, fmt.Errorf("%w: %w", types.ErrTooManyRequests, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("%w while making translation request of type %s for %s: %w", types.ErrGeneric, translation, digest(s), err)
 	}
 
 	defer resp.Body.Close()
+	ts.handleRateLimitHeaders(resp)
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -115,12 +214,16 @@ func (ts *TranslationService) translateWithAPI(ctx context.Context, s string, tr
 
 	if resp.StatusCode != http.StatusOK {
 		detail := getErrorMessage(respBody)
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return nil, fmt.Errorf("%w: translation API rejected the request with status %d, check the API secret: %s", types.ErrUnauthorized, resp.StatusCode, detail)
+		}
 		if resp.StatusCode == http.StatusTooManyRequests {
-			slog.Info("translation API rate limit hit", "detail", detail)
-			return nil, types.ErrTooManyRequests
+			retryAt := ts.heldUntil()
+			slog.Info("translation API rate limit hit", "detail", detail, "retry_at", retryAt)
+			return nil, &types.RateLimitError{RetryAt: retryAt}
 		}
 
-		return nil, fmt.Errorf("%w unexpected status %d from upstream while requesting translation of type %s for [%s]: %s", types.ErrGeneric, resp.StatusCode, string(translation), s, detail)
+		return nil, fmt.Errorf("%w unexpected status %d from upstream while requesting translation of type %s for %s: %s", types.ErrGeneric, resp.StatusCode, string(translation), digest(s), detail)
 	}
 
 	var tslResponse TranslationResponse
@@ -129,29 +232,68 @@ func (ts *TranslationService) translateWithAPI(ctx context.Context, s string, tr
 	}
 
 	if tslResponse.Success.Total != 1 {
-		return nil, fmt.Errorf("%w response for translation of type %s for [%s] has success.total != 1", types.ErrGeneric, translation, s)
+		return nil, fmt.Errorf("%w response for translation of type %s for %s has success.total != 1", types.ErrGeneric, translation, digest(s))
 	}
 	cleaned := utils.RemoveWhitespace(tslResponse.Contents.Translated)
 	return &cleaned, nil
 }
 
+func (ts *TranslationService) Styles() []types.TranslationStyle {
+	return ts.styles.Styles()
+}
+
 func (ts *TranslationService) Translate(ctx context.Context, key, value string, translation types.Translation) (*string, error) {
+	if _, err := ts.styles.Lookup(translation); err != nil {
+		return nil, err
+	}
 	if value == "" {
 		slog.Debug(fmt.Sprintf("translation requested with key %s where value is empty", key))
 		return &value, nil
 	}
 
-	key = key + "_translation"
-	cached, exists := ts.cache.Get(key)
+	// the host is part of the key as the cache can be shared with services
+	// calling other APIs, whose translations differ
+	key = fmt.Sprintf("%s_%s_%s_translation", ts.baseURL.Host, key, translation)
+	cache := ts.cache
+	if isFreeText(ctx) {
+		cache = ts.textCache
+	}
+	cached, exists := cache.Get(key)
 	if exists {
 		return cached.(*string), nil
 	}
 
+	if ts.store != nil && !isFreeText(ctx) {
+		if record, found := ts.store.Get(value, translation, ts.baseURL.Host); found {
+			cache.Put(key, &record.Translated)
+			return &record.Translated, nil
+		}
+	}
+
+	if retryAt := ts.heldUntil(); !retryAt.IsZero() {
+		return nil, &types.RateLimitError{RetryAt: retryAt}
+	}
+
 	translated, err := ts.groupedTranslateWithAPI(ctx, value, translation)
 	if err != nil {
 		return nil, err
 	}
 
-	ts.cache.Put(key, translated)
+	cache.Put(key, translated)
+	if !isFreeText(ctx) {
+		ts.saveRecord(value, translation, *translated)
+	}
 	return translated, nil
 }
+
+// saveRecord keeps the translation in the store, if the service has one. It
+// only logs failures, since the translation itself succeeded.
+func (ts *TranslationService) saveRecord(value string, translation types.Translation, translated string) {
+	if ts.store == nil {
+		return
+	}
+	record := Record{Text: value, Style: translation, Translated: translated, Provider: ts.baseURL.Host, CreatedAt: ts.now().UTC()}
+	if err := ts.store.Put(record); err != nil {
+		slog.Error("failed to save translation", "style", translation, "error", err)
+	}
+}
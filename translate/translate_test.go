@@ -3,8 +3,12 @@ package translate
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -12,6 +16,7 @@ import (
 
 	"github.com/sbaglivi/TL-Pokedex/cache"
 	"github.com/sbaglivi/TL-Pokedex/types"
+	"github.com/sbaglivi/TL-Pokedex/upstream"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/sync/singleflight"
 )
@@ -111,3 +116,640 @@ func TestPokemonServiceSingleflight(t *testing.T) {
 
 	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "expected only one API call")
 }
+
+func TestOpenCircuitIsReportedAsRateLimit(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	breaker := upstream.NewBreaker("funtranslations", upstream.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+	client := &http.Client{Transport: upstream.NewBreakerTransport(srv.Client().Transport, breaker, upstream.RateLimitOrServerFailure)}
+	svc, err := NewTranslationService(cache.NewLRU(10), srv.URL, client)
+	if err != nil {
+		t.Fatalf("failed to instantiate translation service: %v", err)
+	}
+
+	_, err = svc.Translate(context.Background(), "pikachu", "It's a good morning", types.Yoda)
+	assert.ErrorIs(t, err, types.ErrTooManyRequests)
+	_, err = svc.Translate(context.Background(), "raichu", "It's a good evening", types.Yoda)
+	assert.ErrorIs(t, err, types.ErrTooManyRequests)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "the open circuit should short-circuit the second request")
+}
+
+func TestParseRetryAt(t *testing.T) {
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	cases := []struct {
+		header   http.Header
+		expected time.Time
+		found    bool
+	}{
+		{http.Header{"Retry-After": {"120"}}, now.Add(2 * time.Minute), true},
+		{http.Header{"Retry-After": {"Wed, 01 Jan 2025 11:00:00 GMT"}}, now.Add(time.Hour), true},
+		{http.Header{"X-Ratelimit-Reset": {"30"}}, now.Add(30 * time.Second), true},
+		{http.Header{"X-Ratelimit-Reset": {"1735731000"}}, time.Unix(1735731000, 0), true},
+		{http.Header{"Retry-After": {"soon"}}, time.Time{}, false},
+		{http.Header{}, time.Time{}, false},
+	}
+	for _, c := range cases {
+		at, found := parseRetryAt(c.header, now)
+		assert.Equal(t, c.found, found, "%v", c.header)
+		assert.True(t, c.expected.Equal(at), "%v: expected %s got %s", c.header, c.expected, at)
+	}
+}
+
+func TestRateLimitHoldsTranslations(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":{"code":429,"message":"Too Many Requests"}}`))
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", "3600")
+		_, _ = w.Write([]byte(`{"success":{"total":1},"contents":{"translated":"A good morning it is"}}`))
+	}))
+	defer srv.Close()
+
+	svc, err := NewTranslationService(cache.NewLRU(10), srv.URL, srv.Client())
+	if err != nil {
+		t.Fatalf("failed to instantiate translation service: %v", err)
+	}
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	var rateLimit *types.RateLimitError
+	_, err = svc.Translate(ctx, "pikachu", "It's a good morning", types.Yoda)
+	assert.ErrorIs(t, err, types.ErrTooManyRequests)
+	if assert.ErrorAs(t, err, &rateLimit) {
+		assert.Equal(t, now.Add(2*time.Minute), rateLimit.RetryAt)
+	}
+
+	_, err = svc.Translate(ctx, "pikachu", "It's a good morning", types.Yoda)
+	assert.ErrorAs(t, err, &rateLimit)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "no calls should be made before Retry-After")
+
+	now = now.Add(2 * time.Minute)
+	translated, err := svc.Translate(ctx, "pikachu", "It's a good morning", types.Yoda)
+	if err != nil {
+		t.Fatalf("translation failed after Retry-After: %v", err)
+	}
+	assert.Equal(t, "A good morning it is", *translated)
+
+	// the last call used up the quota, which resets in an hour
+	_, err = svc.Translate(ctx, "raichu", "It's a good evening", types.Yoda)
+	if assert.ErrorAs(t, err, &rateLimit) {
+		assert.Equal(t, now.Add(time.Hour), rateLimit.RetryAt)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestRateLimitInThePast(t *testing.T) {
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	for _, header := range []http.Header{
+		{"Retry-After": {"Wed, 01 Jan 2025 09:00:00 GMT"}},
+		{"Retry-After": {"0"}},
+		{"X-Ratelimit-Reset": {"1735718400"}},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		svc, err := NewTranslationService(cache.NewLRU(10), srv.URL, srv.Client())
+		if err != nil {
+			t.Fatalf("failed to instantiate translation service: %v", err)
+		}
+		svc.now = func() time.Time { return now }
+
+		var rateLimit *types.RateLimitError
+		_, err = svc.Translate(context.Background(), "pikachu", "It stores electricity.", types.Yoda)
+		if assert.ErrorAs(t, err, &rateLimit, "%v", header) {
+			assert.Equal(t, now.Add(defaultRetryAfter), rateLimit.RetryAt, "%v", header)
+		}
+		srv.Close()
+	}
+}
+
+func TestBudgetValidate(t *testing.T) {
+	assert.NoError(t, Budget{Limit: 5, Period: time.Hour, Reserved: 1}.Validate())
+	assert.NoError(t, Budget{Limit: 1, Period: time.Hour}.Validate())
+	for _, budget := range []Budget{
+		{Limit: 0, Period: time.Hour},
+		{Limit: -5, Period: time.Hour},
+		{Limit: 5},
+		{Limit: 5, Period: time.Hour, Reserved: 5},
+		{Limit: 5, Period: time.Hour, Reserved: -1},
+	} {
+		assert.ErrorIs(t, budget.Validate(), types.ErrInvalidInput, "%+v", budget)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	quota := NewQuota(
+		Budget{Limit: 3, Period: time.Hour, Reserved: 1},
+		Budget{Limit: 10, Period: 24 * time.Hour},
+	)
+	quota.now = func() time.Time { return now }
+
+	for range 2 {
+		reservation, err := quota.Reserve(types.NormalPriority)
+		if err != nil {
+			t.Fatalf("Reserve failed: %v", err)
+		}
+		reservation.Commit()
+	}
+
+	var rateLimit *types.RateLimitError
+	_, err := quota.Reserve(types.NormalPriority)
+	if assert.ErrorAs(t, err, &rateLimit, "the last call is reserved for high priority") {
+		assert.Equal(t, now.Add(20*time.Minute), rateLimit.RetryAt)
+	}
+
+	reservation, err := quota.Reserve(types.HighPriority)
+	if err != nil {
+		t.Fatalf("high priority Reserve failed: %v", err)
+	}
+	reservation.Cancel()
+	reservation.Cancel()
+	reservation, err = quota.Reserve(types.HighPriority)
+	assert.NoError(t, err, "a canceled reservation is given back once")
+	reservation.Commit()
+	_, err = quota.Reserve(types.HighPriority)
+	assert.ErrorIs(t, err, types.ErrTooManyRequests)
+
+	now = now.Add(20 * time.Minute)
+	_, err = quota.Reserve(types.HighPriority)
+	assert.NoError(t, err, "buckets refill over time")
+}
+
+func TestTranslateRespectsQuota(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(`{"success":{"total":1},"contents":{"translated":"Translated it is"}}`))
+	}))
+	defer srv.Close()
+
+	quota := NewQuota(Budget{Limit: 2, Period: time.Hour, Reserved: 1})
+	svc, err := NewTranslationService(cache.NewLRU(10), srv.URL, srv.Client(), WithQuota(quota))
+	if err != nil {
+		t.Fatalf("failed to instantiate translation service: %v", err)
+	}
+	ctx := context.Background()
+
+	_, err = svc.Translate(ctx, "pikachu", "It's a good morning", types.Yoda)
+	assert.NoError(t, err)
+	_, err = svc.Translate(ctx, "raichu", "It's a good evening", types.Yoda)
+	assert.ErrorIs(t, err, types.ErrTooManyRequests)
+	_, err = svc.Translate(types.WithPriority(ctx, types.HighPriority), "raichu", "It's a good evening", types.Yoda)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "requests over quota must not be sent")
+}
+
+func TestQuotaKeepsCallsThatMayHaveBeenSent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(200 * time.Millisecond):
+		}
+	}))
+	defer srv.Close()
+
+	quota := NewQuota(Budget{Limit: 1, Period: time.Hour})
+	svc, err := NewTranslationService(cache.NewLRU(10), srv.URL, srv.Client(), WithQuota(quota))
+	if err != nil {
+		t.Fatalf("failed to instantiate translation service: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = svc.Translate(ctx, "pikachu", "It's a good morning", types.Yoda)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = quota.Reserve(types.NormalPriority)
+	assert.ErrorIs(t, err, types.ErrTooManyRequests, "timed out requests spend the quota")
+
+	// nothing listens on a closed server, so the request can't be sent
+	srv.Close()
+	quota = NewQuota(Budget{Limit: 1, Period: time.Hour})
+	svc, err = NewTranslationService(cache.NewLRU(10), srv.URL, srv.Client(), WithQuota(quota))
+	if err != nil {
+		t.Fatalf("failed to instantiate translation service: %v", err)
+	}
+	_, err = svc.Translate(context.Background(), "pikachu", "It's a good morning", types.Yoda)
+	assert.ErrorIs(t, err, types.ErrGeneric)
+	_, err = quota.Reserve(types.NormalPriority)
+	assert.NoError(t, err, "requests that were never sent give the call back")
+}
+
+func TestAPISecret(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Funtranslations-Api-Secret") != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"code":401,"message":"Unauthorized"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":{"total":1},"contents":{"translated":"Translated it is"}}`))
+	}))
+	defer srv.Close()
+
+	svc, err := NewTranslationService(cache.NewLRU(10), srv.URL, srv.Client(), WithAPISecret("s3cr3t"))
+	if err != nil {
+		t.Fatalf("failed to instantiate translation service: %v", err)
+	}
+	translated, err := svc.Translate(context.Background(), "pikachu", "It's a good morning", types.Yoda)
+	if err != nil {
+		t.Fatalf("translation with a valid secret failed: %v", err)
+	}
+	assert.Equal(t, "Translated it is", *translated)
+
+	svc, err = NewTranslationService(cache.NewLRU(10), srv.URL, srv.Client(), WithAPISecret("wrong"))
+	if err != nil {
+		t.Fatalf("failed to instantiate translation service: %v", err)
+	}
+	_, err = svc.Translate(context.Background(), "pikachu", "It's a good morning", types.Yoda)
+	assert.ErrorIs(t, err, types.ErrUnauthorized)
+	assert.NotErrorIs(t, err, types.ErrTooManyRequests, "invalid keys are not rate limits")
+	assert.NotContains(t, err.Error(), "wrong")
+}
+
+func TestStyleRegistry(t *testing.T) {
+	styles := NewRegistry(DefaultStyles...)
+	style, err := styles.Lookup(types.Pirate)
+	assert.NoError(t, err)
+	assert.Equal(t, "Pirate", style.Label)
+	_, err = styles.Lookup("elvish")
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+
+	assert.ErrorIs(t, styles.Restrict([]string{"yoda", "elvish"}), types.ErrInvalidInput)
+	assert.NoError(t, styles.Restrict([]string{"yoda", "shakespeare"}))
+	_, err = styles.Lookup(types.Pirate)
+	assert.ErrorIs(t, err, types.ErrInvalidInput, "restricted styles are unavailable")
+	assert.Contains(t, styles.Styles(), types.TranslationStyle{Name: types.Pirate, Label: "Pirate", Available: false})
+	assert.Contains(t, styles.Styles(), types.TranslationStyle{Name: types.Yoda, Label: "Yoda", Available: true})
+	assert.Len(t, styles.Styles(), len(DefaultStyles))
+}
+
+func TestTranslateValidatesStyle(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		_, _ = w.Write([]byte(`{"success":{"total":1},"contents":{"translated":"Ixnay"}}`))
+	}))
+	defer srv.Close()
+
+	svc, err := NewTranslationService(cache.NewLRU(10), srv.URL, srv.Client())
+	if err != nil {
+		t.Fatalf("failed to instantiate translation service: %v", err)
+	}
+	_, err = svc.Translate(context.Background(), "pikachu", "It's a good morning", "elvish")
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+
+	_, err = svc.Translate(context.Background(), "pikachu", "It's a good morning", types.PigLatin)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/pig-latin.json"}, paths)
+}
+
+func TestLocalTranslator(t *testing.T) {
+	local := NewLocal()
+	cases := []struct {
+		style    types.Translation
+		text     string
+		expected string
+	}{
+		{types.Yoda, "It stores electricity in its cheeks.", "Electricity in its cheeks, it stores."},
+		{types.Yoda, "It can fly over mountains. Its tail burns brightly!", "Over mountains, it can fly. Brightly, its tail burns!"},
+		{types.Yoda, "Found in caves.", "Found in caves."},
+		{types.Yoda, "It stores ;", "It stores ;"},
+		{types.Shakespeare, "You are my friend, and it's over.", "Thou art my companion, and 'tis o'er."},
+		{types.Shakespeare, "It follows you, so you can never escape.", "It follows thee, so thou can ne'er escape."},
+		{types.Pirate, "Hello, the treasure is yours.", "Ahoy, th' booty be yours."},
+	}
+	for _, c := range cases {
+		translated, err := local.Translate(context.Background(), "pikachu", c.text, c.style)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, *translated, c.text)
+	}
+
+	assert.False(t, local.Supports(types.Klingon))
+	_, err := local.Translate(context.Background(), "pikachu", "It stores electricity.", types.Klingon)
+	assert.ErrorIs(t, err, types.ErrGeneric)
+}
+
+// translatorFunc lets tests write providers inline
+type translatorFunc func(context.Context, string, string, types.Translation) (*string, error)
+
+func (f translatorFunc) Translate(ctx context.Context, key, value string, translation types.Translation) (*string, error) {
+	return f(ctx, key, value, translation)
+}
+
+func TestChain(t *testing.T) {
+	retryAt := time.Date(2025, 1, 1, 11, 0, 0, 0, time.UTC)
+	rateLimited := translatorFunc(func(context.Context, string, string, types.Translation) (*string, error) {
+		return nil, &types.RateLimitError{RetryAt: retryAt}
+	})
+	slow := translatorFunc(func(ctx context.Context, _, _ string, _ types.Translation) (*string, error) {
+		<-ctx.Done()
+		return nil, fmt.Errorf("%w: %v", types.ErrGeneric, ctx.Err())
+	})
+	invalid := translatorFunc(func(context.Context, string, string, types.Translation) (*string, error) {
+		return nil, fmt.Errorf("%w: unknown style", types.ErrInvalidInput)
+	})
+	var called []string
+	echo := func(name string) translatorFunc {
+		return func(_ context.Context, _, value string, _ types.Translation) (*string, error) {
+			called = append(called, name)
+			translated := name + ": " + value
+			return &translated, nil
+		}
+	}
+	ctx := context.Background()
+
+	chain := NewChain(
+		Provider{Name: "remote", Translator: rateLimited},
+		Provider{Name: "self-hosted", Translator: slow, Timeout: 10 * time.Millisecond},
+		Provider{Name: "local", Translator: echo("local")},
+	)
+	translated, provider, err := chain.TranslateWithProvider(ctx, "pikachu", "It stores electricity.", types.Yoda)
+	assert.NoError(t, err)
+	assert.Equal(t, "local", provider)
+	assert.Equal(t, "local: It stores electricity.", *translated)
+
+	chain = NewChain(Provider{Name: "remote", Translator: echo("remote")}, Provider{Name: "local", Translator: echo("local")})
+	_, provider, err = chain.TranslateWithProvider(ctx, "pikachu", "It stores electricity.", types.Yoda)
+	assert.NoError(t, err)
+	assert.Equal(t, "remote", provider)
+	assert.Equal(t, []string{"local", "remote"}, called, "providers after a successful one are not called")
+
+	// approximations are skipped when asking for exact translations
+	chain = NewChain(Provider{Name: "remote", Translator: rateLimited}, Provider{Name: "local", Translator: echo("local"), Approximate: true})
+	_, _, err = chain.TranslateWithProvider(types.WithExactTranslation(ctx), "pikachu", "It stores electricity.", types.Yoda)
+	assert.ErrorAs(t, err, new(*types.RateLimitError))
+	assert.Equal(t, []string{"local", "remote"}, called)
+
+	// the first provider's error is the one reported
+	chain = NewChain(Provider{Name: "remote", Translator: rateLimited}, Provider{Name: "self-hosted", Translator: slow, Timeout: 10 * time.Millisecond})
+	_, err = chain.Translate(ctx, "pikachu", "It stores electricity.", types.Yoda)
+	var rateLimit *types.RateLimitError
+	assert.ErrorAs(t, err, &rateLimit)
+
+	// invalid input and canceled requests are not passed on
+	called = nil
+	chain = NewChain(Provider{Name: "remote", Translator: invalid}, Provider{Name: "local", Translator: echo("local")})
+	_, err = chain.Translate(ctx, "pikachu", "It stores electricity.", "elvish")
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	chain = NewChain(Provider{Name: "self-hosted", Translator: slow}, Provider{Name: "local", Translator: echo("local")})
+	_, err = chain.Translate(canceled, "pikachu", "It stores electricity.", types.Yoda)
+	assert.ErrorIs(t, err, types.ErrGeneric)
+	assert.Empty(t, called)
+
+	_, err = NewChain().Translate(ctx, "pikachu", "It stores electricity.", types.Yoda)
+	assert.ErrorIs(t, err, types.ErrGeneric)
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "translations.jsonl")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	created := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	yoda := Record{Text: "It stores electricity.", Style: types.Yoda, Translated: "Electricity, it stores.", Provider: "api.funtranslations.com", CreatedAt: created}
+	assert.NoError(t, store.Put(yoda))
+	assert.NoError(t, store.Put(Record{Text: "It burrows.", Style: types.Pirate, Translated: "It burrows, arr.", Provider: "local", CreatedAt: created.Add(time.Hour)}))
+	assert.NoError(t, store.Close())
+
+	// a crash may leave a partial line behind
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	_, _ = f.WriteString(`{"text": "It sl`)
+	f.Close()
+
+	store, err = OpenStore(path)
+	if err != nil {
+		t.Fatalf("reopening store: %v", err)
+	}
+	defer store.Close()
+	record, found := store.Get("It stores electricity.", types.Yoda, "api.funtranslations.com")
+	assert.True(t, found)
+	assert.Equal(t, yoda, record)
+	_, found = store.Get("It stores electricity.", types.Pirate, "api.funtranslations.com")
+	assert.False(t, found)
+
+	// records put after the partial line survive the next restart
+	sleeps := Record{Text: "It sleeps.", Style: types.Yoda, Translated: "Sleeps, it does.", Provider: "local", CreatedAt: created.Add(2 * time.Hour)}
+	assert.NoError(t, store.Put(sleeps))
+	assert.NoError(t, store.Close())
+	store, err = OpenStore(path)
+	if err != nil {
+		t.Fatalf("reopening store: %v", err)
+	}
+	defer store.Close()
+	record, found = store.Get("It sleeps.", types.Yoda, "local")
+	assert.True(t, found)
+	assert.Equal(t, sleeps, record)
+
+	var exported strings.Builder
+	assert.NoError(t, store.Export(&exported))
+	lines := strings.Split(strings.TrimSpace(exported.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "Electricity, it stores.", "records are exported oldest first")
+
+	seeded, err := OpenStore(filepath.Join(t.TempDir(), "seeded.jsonl"))
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	defer seeded.Close()
+	added, err := seeded.Import(strings.NewReader(exported.String()))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, added)
+	added, err = seeded.Import(strings.NewReader(exported.String()))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, added, "known records are not imported twice")
+	_, err = seeded.Import(strings.NewReader("not json\n"))
+	assert.ErrorIs(t, err, types.ErrInvalidInput)
+}
+
+func TestTranslateUsesStore(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"success":{"total":1},"contents":{"translated":"Electricity, it stores."}}`))
+	}))
+	defer srv.Close()
+
+	store, err := OpenStore(filepath.Join(t.TempDir(), "translations.jsonl"))
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	defer store.Close()
+
+	for range 2 {
+		// a new cache, as after a restart
+		svc, err := NewTranslationService(cache.NewLRU(10), srv.URL, srv.Client(), WithStore(store))
+		if err != nil {
+			t.Fatalf("failed to instantiate translation service: %v", err)
+		}
+		translated, err := svc.Translate(context.Background(), "pikachu", "It stores electricity.", types.Yoda)
+		assert.NoError(t, err)
+		assert.Equal(t, "Electricity, it stores.", *translated)
+	}
+	assert.Equal(t, int32(1), calls.Load())
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	record, found := store.Get("It stores electricity.", types.Yoda, host)
+	assert.True(t, found)
+	assert.Equal(t, host, record.Provider)
+	assert.False(t, record.CreatedAt.IsZero())
+
+	// services calling other APIs don't serve these translations as their own,
+	// not even when sharing the cache
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"success":{"total":1},"contents":{"translated":"Stores electricity, it does."}}`))
+	}))
+	defer other.Close()
+	shared := cache.NewLRU(10)
+	for _, baseURL := range []string{srv.URL, other.URL} {
+		svc, err := NewTranslationService(shared, baseURL, http.DefaultClient, WithStore(store))
+		if err != nil {
+			t.Fatalf("failed to instantiate translation service: %v", err)
+		}
+		translated, err := svc.Translate(context.Background(), "pikachu", "It stores electricity.", types.Yoda)
+		assert.NoError(t, err)
+		if baseURL == other.URL {
+			assert.Equal(t, "Stores electricity, it does.", *translated)
+		}
+	}
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, 2, store.Len())
+}
+
+func TestTextTranslator(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":{"total":1},"contents":{"translated":"Ahoy, matey"}}`))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "translations.jsonl")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	defer store.Close()
+	svc, err := NewTranslationService(cache.NewLRU(10), srv.URL, srv.Client(), WithStore(store), WithTextCache(cache.NewLRU(10)))
+	if err != nil {
+		t.Fatalf("failed to instantiate translation service: %v", err)
+	}
+	text := NewTextTranslator(NewChain(Provider{Name: "funtranslations", Translator: svc}), 20)
+	ctx := context.Background()
+
+	for range 2 {
+		result, err := text.TranslateText(ctx, " Hello, friend ", types.Pirate)
+		assert.NoError(t, err)
+		assert.Equal(t, &types.TranslateTextResult{Translated: "Ahoy, matey", Style: types.Pirate, Engine: "funtranslations"}, result)
+	}
+	assert.Equal(t, int32(1), calls.Load(), "translations of the same text are cached")
+	assert.Equal(t, 0, store.Len(), "texts sent by users are not stored")
+	saved, _ := os.ReadFile(path)
+	assert.Empty(t, saved)
+
+	// failures don't reveal the text
+	_, err = text.TranslateText(ctx, "something rude", types.Pirate)
+	assert.ErrorIs(t, err, types.ErrGeneric)
+	assert.NotContains(t, err.Error(), "rude")
+
+	for _, c := range []struct {
+		text  string
+		style types.Translation
+	}{
+		{"   ", types.Pirate},
+		{strings.Repeat("é", 21), types.Pirate},
+		{"Hello", ""},
+		{"Hello", "elvish"},
+	} {
+		_, err := text.TranslateText(ctx, c.text, c.style)
+		assert.ErrorIs(t, err, types.ErrInvalidInput, c.text)
+	}
+}
+
+func TestTextTranslatorCache(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"success":{"total":1},"contents":{"translated":"Ahoy"}}`))
+	}))
+	defer srv.Close()
+
+	svc, err := NewTranslationService(cache.NewLRU(1), srv.URL, srv.Client(), WithTextCache(cache.NewLRU(10)))
+	if err != nil {
+		t.Fatalf("failed to instantiate translation service: %v", err)
+	}
+	text := NewTextTranslator(NewChain(Provider{Name: "funtranslations", Translator: svc}), 20)
+	ctx := context.Background()
+
+	_, err = svc.Translate(ctx, "pikachu", "It stores electricity.", types.Pirate)
+	assert.NoError(t, err)
+	for _, s := range []string{"Hello", "Goodbye", "Hello"} {
+		_, err = text.TranslateText(ctx, s, types.Pirate)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(3), calls.Load(), "texts are cached")
+	_, err = svc.Translate(ctx, "pikachu", "It stores electricity.", types.Pirate)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load(), "texts must not evict pokemon descriptions")
+
+	uncached, err := NewTranslationService(cache.NewLRU(10), srv.URL, srv.Client())
+	if err != nil {
+		t.Fatalf("failed to instantiate translation service: %v", err)
+	}
+	text = NewTextTranslator(NewChain(Provider{Name: "funtranslations", Translator: uncached}), 20)
+	for range 2 {
+		_, err = text.TranslateText(ctx, "Hello", types.Pirate)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(5), calls.Load(), "texts are not cached without a text cache")
+}
+
+func TestTranslationErrorsKeepTheirCause(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(200 * time.Millisecond):
+		}
+	}))
+	defer srv.Close()
+
+	svc, err := NewTranslationService(cache.NewLRU(10), srv.URL, srv.Client())
+	if err != nil {
+		t.Fatalf("failed to instantiate translation service: %v", err)
+	}
+	chain := NewChain(
+		Provider{Name: "funtranslations", Translator: svc, Timeout: 20 * time.Millisecond},
+		Provider{Name: "local", Translator: NewLocal()},
+	)
+	_, err = chain.Translate(context.Background(), "pikachu", "It stores electricity.", types.Klingon)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	var providerErr *types.ProviderError
+	assert.ErrorAs(t, err, &providerErr)
+	assert.Equal(t, "funtranslations", providerErr.Provider)
+
+	quota := NewQuota(Budget{Limit: 1, Period: time.Hour})
+	_, _ = quota.Reserve(types.NormalPriority)
+	_, err = quota.Reserve(types.NormalPriority)
+	var rateLimit *types.RateLimitError
+	assert.ErrorAs(t, err, &rateLimit)
+	assert.True(t, rateLimit.Quota)
+}
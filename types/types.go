@@ -1,20 +1,127 @@
 package types
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
 
 type Translation string
 
 const (
 	Yoda        Translation = "yoda"
 	Shakespeare Translation = "shakespeare"
+	Pirate      Translation = "pirate"
+	Minion      Translation = "minion"
+	Klingon     Translation = "klingon"
+	PigLatin    Translation = "pig-latin"
+	Morse       Translation = "morse"
+	Sith        Translation = "sith"
+	Gungan      Translation = "gungan"
+	Valyrian    Translation = "valyrian"
+	Dothraki    Translation = "dothraki"
+	Cockney     Translation = "cockney"
 )
 
 var (
 	ErrNotFound        = errors.New("not found")
 	ErrTooManyRequests = errors.New("too many requests")
 	ErrGeneric         = errors.New("generic error")
+	ErrInvalidInput    = errors.New("invalid input")
+	ErrUnauthorized    = errors.New("unauthorized")
+)
+
+// RateLimitError is returned when an upstream rate limited us, RetryAt is
+// when it can be called again
+type RateLimitError struct {
+	RetryAt time.Time
+	// set when our own quota ran out, rather than the upstream's
+	Quota bool
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v until %s", ErrTooManyRequests, e.RetryAt.Format(time.RFC3339))
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrTooManyRequests
+}
+
+// InputError is an ErrInvalidInput whose Message is meant for clients, free
+// of the context added while it is passed up
+type InputError struct {
+	Message string
+}
+
+func InvalidInput(format string, args ...any) error {
+	return &InputError{Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrInvalidInput, e.Message)
+}
+
+func (e *InputError) Unwrap() error {
+	return ErrInvalidInput
+}
+
+// InputMessage is what clients are told about an ErrInvalidInput err: the
+// message of its InputError, or BadRequest if it has none
+func InputMessage(err error) string {
+	var input *InputError
+	if errors.As(err, &input) {
+		return input.Message
+	}
+	return string(BadRequest)
+}
+
+// ProviderError is a failure of a named translation provider
+type ProviderError struct {
+	Provider string
+	Err      error
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("provider %s: %v", e.Provider, e.Err)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+type Priority int
+
+const (
+	NormalPriority Priority = iota
+	// HighPriority requests may spend quota reserved for them
+	HighPriority
 )
 
+type priorityKey struct{}
+
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func PriorityFrom(ctx context.Context) Priority {
+	priority, _ := ctx.Value(priorityKey{}).(Priority)
+	return priority
+}
+
+type exactKey struct{}
+
+// WithExactTranslation asks for translations made by the real translators
+// only, not approximated, e.g. for jobs that can wait for the quota instead
+func WithExactTranslation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, exactKey{}, true)
+}
+
+func ExactTranslationFrom(ctx context.Context) bool {
+	exact, _ := ctx.Value(exactKey{}).(bool)
+	return exact
+}
+
 type Cache interface {
 	Get(key string) (any, bool)
 	Put(key string, value any)
@@ -24,8 +131,11 @@ type HTTPError string
 
 const (
 	NotFound            HTTPError = "not found"
+	BadRequest          HTTPError = "invalid input"
 	InternalServerError HTTPError = "internal server error"
 	Timeout             HTTPError = "request timed out"
+	TooManyRequests     HTTPError = "too many requests"
+	Unauthorized        HTTPError = "unauthorized"
 )
 
 func (err HTTPError) Wrap() map[string]string {
@@ -33,13 +143,247 @@ func (err HTTPError) Wrap() map[string]string {
 }
 
 type Pokemon struct {
-	IsLegendary bool   `json:"is_legendary"`
-	Name        string `json:"name"`
-	Habitat     string `json:"habitat"`
-	Desc        string `json:"desc"`
+	IsLegendary    bool     `json:"is_legendary"`
+	IsMythical     bool     `json:"is_mythical"`
+	Name           string   `json:"name"`
+	Habitat        string   `json:"habitat"`
+	Generation     string   `json:"generation"`
+	Desc           string   `json:"desc"`
+	Varieties      []string `json:"varieties,omitempty"`
+	DefaultVariety string   `json:"-"`
+}
+
+type Stat struct {
+	Name string `json:"name"`
+	Base int    `json:"base"`
+}
+
+// Variety holds the battle data of a pokemon form, which PokéAPI keeps
+// separate from the species
+type Variety struct {
+	Name    string   `json:"name"`
+	Species string   `json:"species"`
+	Types   []string `json:"types"`
+	Stats   []Stat   `json:"stats"`
+	Height  int      `json:"height"`
+	Weight  int      `json:"weight"`
+}
+
+type TypeDefense struct {
+	Weaknesses  map[string]float64 `json:"weaknesses"`
+	Resistances map[string]float64 `json:"resistances"`
+	Immunities  []string           `json:"immunities"`
+}
+
+type PokemonDetails struct {
+	Pokemon *Pokemon     `json:"pokemon"`
+	Variety *Variety     `json:"variety"`
+	Defense *TypeDefense `json:"defense"`
+}
+
+type TypeMatchup struct {
+	Attacker   string   `json:"attacker"`
+	Defender   []string `json:"defender"`
+	Multiplier float64  `json:"multiplier"`
+}
+
+type RandomOptions struct {
+	Seed       string
+	Habitat    string
+	Generation string
 }
 
 type GetPokemonResult struct {
-	Pokemon  *Pokemon `json:"pokemon"`
-	Warnings []string `json:"warnings,omitempty"`
+	Pokemon *Pokemon `json:"pokemon"`
+	Form    *Variety `json:"form,omitempty"`
+	// the style the description was translated with, if it was
+	Translation Translation `json:"translation,omitempty"`
+	// the engine that produced the translation, e.g. "funtranslations" or "local"
+	Engine string `json:"engine,omitempty"`
+	// Warnings holds the messages of WarningDetails, as returned before
+	// warnings were structured
+	Warnings       []string  `json:"warnings,omitempty"`
+	WarningDetails []Warning `json:"warning_details,omitempty"`
+}
+
+type WarningCode string
+
+const (
+	WarningRateLimited         WarningCode = "rate_limited"
+	WarningQuotaExhausted      WarningCode = "quota_exhausted"
+	WarningUpstreamUnavailable WarningCode = "upstream_unavailable"
+	WarningTimeout             WarningCode = "timeout"
+	WarningUnauthorized        WarningCode = "unauthorized"
+	WarningTranslationFailed   WarningCode = "translation_failed"
+)
+
+type Warning struct {
+	Code    WarningCode `json:"code"`
+	Message string      `json:"message"`
+	// when translations are expected to be available again
+	RetryAfter *time.Time `json:"retry_after,omitempty"`
+	// the translation provider that failed, when known
+	Provider string `json:"provider,omitempty"`
+}
+
+type PopularPokemon struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+type GetPopularResult struct {
+	Window  string           `json:"window"`
+	Pokemon []PopularPokemon `json:"pokemon"`
+}
+
+type Difference[T comparable] struct {
+	A    T    `json:"a"`
+	B    T    `json:"b"`
+	Same bool `json:"same"`
+}
+
+type StatComparison struct {
+	Name   string `json:"name"`
+	A      int    `json:"a"`
+	B      int    `json:"b"`
+	Winner string `json:"winner"`
+}
+
+type TypesComparison struct {
+	Shared []string `json:"shared"`
+	OnlyA  []string `json:"only_a"`
+	OnlyB  []string `json:"only_b"`
+}
+
+type ComparisonDiff struct {
+	Stats     []StatComparison   `json:"stats"`
+	Types     TypesComparison    `json:"types"`
+	Habitat   Difference[string] `json:"habitat"`
+	Legendary Difference[bool]   `json:"legendary"`
+	Height    Difference[int]    `json:"height"`
+	Weight    Difference[int]    `json:"weight"`
+}
+
+type ComparisonSummary struct {
+	AWins  int    `json:"a_wins"`
+	BWins  int    `json:"b_wins"`
+	Ties   int    `json:"ties"`
+	TotalA int    `json:"total_a"`
+	TotalB int    `json:"total_b"`
+	Winner string `json:"winner"`
+}
+
+type Comparison struct {
+	A       *PokemonDetails   `json:"a"`
+	B       *PokemonDetails   `json:"b"`
+	Diff    ComparisonDiff    `json:"diff"`
+	Summary ComparisonSummary `json:"summary"`
+}
+
+type TeamRequest struct {
+	Members []string `json:"members"`
+}
+
+type TeamVulnerability struct {
+	Type      string   `json:"type"`
+	Weak      []string `json:"weak"`
+	Resistant []string `json:"resistant"`
+	Score     int      `json:"score"`
+}
+
+type TeamStats struct {
+	Totals  []Stat         `json:"totals"`
+	Members map[string]int `json:"members"`
+	Total   int            `json:"total"`
+}
+
+type TeamAnalysis struct {
+	Members          []*PokemonDetails   `json:"members"`
+	Coverage         []string            `json:"coverage"`
+	UncoveredTypes   []string            `json:"uncovered_types"`
+	SharedWeaknesses map[string][]string `json:"shared_weaknesses"`
+	Vulnerabilities  []TeamVulnerability `json:"vulnerabilities"`
+	Stats            TeamStats           `json:"stats"`
+}
+
+type SearchResult struct {
+	Name    string  `json:"name"`
+	Field   string  `json:"field"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Results []SearchResult `json:"results"`
+}
+
+type UpstreamStatus struct {
+	Name     string     `json:"name"`
+	State    string     `json:"state"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+}
+
+type StatusResponse struct {
+	Upstreams []UpstreamStatus `json:"upstreams"`
+}
+
+type TranslationStyle struct {
+	Name      Translation `json:"name"`
+	Label     string      `json:"label"`
+	Available bool        `json:"available"`
+}
+
+type TranslationStylesResponse struct {
+	Styles []TranslationStyle `json:"styles"`
+}
+
+type ReloadRulesResult struct {
+	Rules int `json:"rules"`
+}
+
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+type TranslationJobRequest struct {
+	Style Translation `json:"style"`
+	// where the job is POSTed once it's done or failed
+	CallbackURL string `json:"callback_url"`
+}
+
+// TranslationJob is the translation of a pokemon's description, done in the
+// background
+type TranslationJob struct {
+	ID       string      `json:"id"`
+	Status   JobStatus   `json:"status"`
+	Pokemon  string      `json:"pokemon"`
+	Style    Translation `json:"style,omitempty"`
+	Attempts int         `json:"attempts"`
+	// when a job waiting for the translation quota will be tried again
+	RetryAt     *time.Time        `json:"retry_at,omitempty"`
+	Result      *GetPokemonResult `json:"result,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	CallbackURL string            `json:"callback_url,omitempty"`
+	// CallbackStatus is pending until the callback is delivered or fails
+	CallbackStatus string `json:"callback_status,omitempty"`
+}
+
+type TranslateTextRequest struct {
+	Text  string      `json:"text"`
+	Style Translation `json:"style"`
+}
+
+type TranslateTextResult struct {
+	Translated string      `json:"translated"`
+	Style      Translation `json:"style"`
+	Engine     string      `json:"engine,omitempty"`
 }
@@ -0,0 +1,181 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+)
+
+var ErrCircuitOpen = errors.New("circuit open")
+
+type BreakerState string
+
+const (
+	Closed   BreakerState = "closed"
+	Open     BreakerState = "open"
+	HalfOpen BreakerState = "half-open"
+)
+
+type BreakerConfig struct {
+	// consecutive failures that open the circuit
+	FailureThreshold int
+	// how long the circuit stays open before letting probes through
+	OpenTimeout time.Duration
+	// concurrent probes allowed while half-open
+	HalfOpenRequests int
+}
+
+var DefaultBreakerConfig = BreakerConfig{
+	FailureThreshold: 5,
+	OpenTimeout:      30 * time.Second,
+	HalfOpenRequests: 1,
+}
+
+type Breaker struct {
+	name   string
+	config BreakerConfig
+	now    func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probes   int
+}
+
+func NewBreaker(name string, config BreakerConfig) *Breaker {
+	return &Breaker{
+		name:   name,
+		config: config,
+		now:    time.Now,
+		state:  Closed,
+	}
+}
+
+// must be called with b.mu held
+func (b *Breaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	slog.Warn("circuit breaker state changed", "upstream", b.name, "from", b.state, "to", state, "failures", b.failures)
+	b.state = state
+	b.probes = 0
+	if state == Open {
+		b.openedAt = b.now()
+	}
+}
+
+// Allow reports whether a request may be sent. Every allowed request must
+// be followed by a call to Success, Failure or Release.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.config.OpenTimeout {
+		b.setState(HalfOpen)
+	}
+	switch b.state {
+	case Open:
+		return fmt.Errorf("%w for %s", ErrCircuitOpen, b.name)
+	case HalfOpen:
+		if b.probes >= b.config.HalfOpenRequests {
+			return fmt.Errorf("%w for %s, waiting for probe", ErrCircuitOpen, b.name)
+		}
+		b.probes++
+	}
+	return nil
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.setState(Closed)
+}
+
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.config.FailureThreshold {
+		b.setState(Open)
+	}
+}
+
+// Release gives back an allowed request without judging the upstream, e.g.
+// when the caller gave up on it
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == HalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *Breaker) Status() types.UpstreamStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := types.UpstreamStatus{Name: b.name, State: string(b.state), Failures: b.failures}
+	if b.state != Closed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+// ServerFailure counts transport errors and 5xx responses as failures
+func ServerFailure(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500
+}
+
+// RateLimitOrServerFailure also opens the circuit on 429s, for upstreams
+// whose rate limits last long enough that retrying right away is pointless
+func RateLimitOrServerFailure(resp *http.Response, err error) bool {
+	return ServerFailure(resp, err) || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// BreakerTransport fails fast with ErrCircuitOpen while its breaker is open
+type BreakerTransport struct {
+	base      http.RoundTripper
+	breaker   *Breaker
+	isFailure func(*http.Response, error) bool
+}
+
+func NewBreakerTransport(base http.RoundTripper, breaker *Breaker, isFailure func(*http.Response, error) bool) *BreakerTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &BreakerTransport{base: base, breaker: breaker, isFailure: isFailure}
+}
+
+func (t *BreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.Allow(); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case errors.Is(err, context.Canceled):
+		// the caller went away, which says nothing about the upstream, while
+		// timeouts do count as failures
+		t.breaker.Release()
+	case t.isFailure(resp, err):
+		t.breaker.Failure()
+	default:
+		t.breaker.Success()
+	}
+	return resp, err
+}
@@ -0,0 +1,137 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+)
+
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    time.Second,
+}
+
+// RetryTransport retries requests that failed because of transport errors or
+// 5xx responses. Idempotent requests are retried on both, others only when
+// the connection couldn't be established, since then the upstream never saw
+// them. Retries stop when the next wait would outlive the request context.
+type RetryTransport struct {
+	base   http.RoundTripper
+	policy RetryPolicy
+	now    func() time.Time
+	sleep  func(context.Context, time.Duration) bool
+}
+
+func NewRetryTransport(base http.RoundTripper, policy RetryPolicy) *RetryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RetryTransport{base: base, policy: policy, now: time.Now, sleep: sleep}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func isConnectionError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// NotSent reports whether a request that failed with err certainly never
+// reached the upstream: its circuit was open or no connection was made
+func NotSent(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || isConnectionError(err)
+}
+
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false
+		}
+		return isIdempotent(req.Method) || isConnectionError(err)
+	}
+	return resp.StatusCode >= 500 && isIdempotent(req.Method)
+}
+
+// Backoff returns a random delay up to BaseDelay * 2^attempt ("full jitter"),
+// capped at MaxDelay
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := min(p.BaseDelay<<attempt, p.MaxDelay)
+	if delay <= 0 {
+		return 0
+	}
+	return rand.N(delay)
+}
+
+// wait sleeps for delay, returning false if the context would expire first
+func (t *RetryTransport) wait(ctx context.Context, delay time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(t.now()) < delay {
+		return false
+	}
+	return t.sleep(ctx, delay)
+}
+
+// sleep sleeps for delay, returning false if the context is done first
+func sleep(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= t.policy.MaxAttempts || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+
+		delay := t.policy.Backoff(attempt - 1)
+		if !t.wait(ctx, delay) {
+			return resp, err
+		}
+
+		if resp != nil {
+			slog.Warn("retrying upstream request", "url", req.URL.Redacted(), "attempt", attempt, "status", resp.StatusCode)
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		} else {
+			slog.Warn("retrying upstream request", "url", req.URL.Redacted(), "attempt", attempt, "error", err)
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+	}
+}
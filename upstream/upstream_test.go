@@ -0,0 +1,238 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sbaglivi/TL-Pokedex/types"
+	"github.com/stretchr/testify/assert"
+)
+
+var testPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+// flakyServer fails the first `failures` requests with status, or by closing
+// the connection when status is 0
+func flakyServer(failures int32, status int, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		if n <= failures {
+			if status == 0 {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			w.WriteHeader(status)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(append([]byte("ok "), body...))
+	}))
+}
+
+func newClient(base http.RoundTripper) *http.Client {
+	return &http.Client{Transport: NewRetryTransport(base, testPolicy)}
+}
+
+func TestRetriesIdempotentRequests(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+	}{
+		{"5xx", http.StatusServiceUnavailable},
+		{"transport error", 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var calls int32
+			srv := flakyServer(2, c.status, &calls)
+			defer srv.Close()
+
+			resp, err := newClient(srv.Client().Transport).Get(srv.URL)
+			if err != nil {
+				t.Fatalf("GET failed: %v", err)
+			}
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestGivesUpAfterMaxAttempts(t *testing.T) {
+	var calls int32
+	srv := flakyServer(10, http.StatusBadGateway, &calls)
+	defer srv.Close()
+
+	resp, err := newClient(srv.Client().Transport).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode, "the last response is returned")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestDoesNotRetryClientErrors(t *testing.T) {
+	var calls int32
+	srv := flakyServer(10, http.StatusTooManyRequests, &calls)
+	defer srv.Close()
+
+	resp, err := newClient(srv.Client().Transport).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestPostIsNotRetriedOnServerErrors(t *testing.T) {
+	var calls int32
+	srv := flakyServer(1, http.StatusInternalServerError, &calls)
+	defer srv.Close()
+
+	resp, err := newClient(srv.Client().Transport).Post(srv.URL, "application/json", bytes.NewBufferString(`{}`))
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, 0)
+	srv = flakyServer(1, 0, &calls)
+	defer srv.Close()
+	_, err = newClient(srv.Client().Transport).Post(srv.URL, "application/json", bytes.NewBufferString(`{}`))
+	assert.Error(t, err, "a dropped connection may have been processed, so it isn't retried")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+// refuseFirst fails the first request as if the connection was refused
+type refuseFirst struct {
+	base    http.RoundTripper
+	refused atomic.Bool
+}
+
+func (rt *refuseFirst) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt.refused.CompareAndSwap(false, true) {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: io.ErrUnexpectedEOF}
+	}
+	return rt.base.RoundTrip(req)
+}
+
+func TestPostIsRetriedOnConnectionErrors(t *testing.T) {
+	var calls int32
+	srv := flakyServer(0, 0, &calls)
+	defer srv.Close()
+
+	client := newClient(&refuseFirst{base: srv.Client().Transport})
+	resp, err := client.Post(srv.URL, "application/json", bytes.NewBufferString(`{"text":"hi"}`))
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, `ok {"text":"hi"}`, string(body), "the body is sent again")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRetriesStopAtContextDeadline(t *testing.T) {
+	var calls int32
+	srv := flakyServer(100, http.StatusServiceUnavailable, &calls)
+	defer srv.Close()
+
+	// waits only move a fake clock forward, so that the test doesn't depend
+	// on how fast it runs
+	now := time.Now()
+	deadline := now.Add(1500 * time.Millisecond)
+	var waits int32
+	policy := RetryPolicy{MaxAttempts: 100, BaseDelay: time.Second, MaxDelay: time.Second}
+	transport := NewRetryTransport(srv.Client().Transport, policy)
+	transport.now = func() time.Time { return now }
+	transport.sleep = func(_ context.Context, delay time.Duration) bool {
+		waits++
+		now = now.Add(delay)
+		return true
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "the last response is returned")
+		resp.Body.Close()
+	}
+	assert.False(t, now.After(deadline), "no wait outlives the context")
+	assert.Equal(t, waits+1, atomic.LoadInt32(&calls))
+	assert.Less(t, atomic.LoadInt32(&calls), int32(policy.MaxAttempts))
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	now := time.Now()
+	b := NewBreaker("test", BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+	b.now = func() time.Time { return now }
+
+	assert.NoError(t, b.Allow())
+	b.Failure()
+	assert.NoError(t, b.Allow())
+	b.Success()
+	assert.Equal(t, 0, b.Status().Failures, "failures must be consecutive")
+
+	for range 2 {
+		assert.NoError(t, b.Allow())
+		b.Failure()
+	}
+	assert.Equal(t, string(Open), b.Status().State)
+	assert.ErrorIs(t, b.Allow(), ErrCircuitOpen)
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, b.Allow(), "a probe is let through after the timeout")
+	assert.Equal(t, string(HalfOpen), b.Status().State)
+	assert.ErrorIs(t, b.Allow(), ErrCircuitOpen, "only one probe at a time")
+	b.Failure()
+	assert.Equal(t, string(Open), b.Status().State, "a failed probe opens the circuit again")
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, b.Allow())
+	b.Release()
+	assert.NoError(t, b.Allow(), "a released probe frees its slot")
+	b.Success()
+	assert.Equal(t, types.UpstreamStatus{Name: "test", State: string(Closed)}, b.Status())
+}
+
+func TestBreakerTransportFailsFast(t *testing.T) {
+	var calls int32
+	srv := flakyServer(10, http.StatusTooManyRequests, &calls)
+	defer srv.Close()
+
+	breaker := NewBreaker("funtranslations", BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+	client := &http.Client{Transport: NewBreakerTransport(srv.Client().Transport, breaker, RateLimitOrServerFailure)}
+	for range 2 {
+		resp, err := client.Post(srv.URL, "application/json", bytes.NewBufferString(`{}`))
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	_, err := client.Post(srv.URL, "application/json", bytes.NewBufferString(`{}`))
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// 429s are not failures for upstreams that only count server errors
+	breaker = NewBreaker("pokeapi", BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+	client = &http.Client{Transport: NewBreakerTransport(srv.Client().Transport, breaker, ServerFailure)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, string(Closed), breaker.Status().State)
+}
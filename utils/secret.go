@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Secret is an API secret that never shows up in logs or formatted output
+type Secret string
+
+const redacted = "[REDACTED]"
+
+func (s Secret) String() string {
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return redacted
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(redacted)
+}
+
+// LoadSecret reads the secret from the env var name or, when that isn't set,
+// from the file named by name+"_FILE" (e.g. a docker or kubernetes secret).
+// It returns an empty secret when neither is set.
+func LoadSecret(name string) (Secret, error) {
+	if value := os.Getenv(name); value != "" {
+		return Secret(strings.TrimSpace(value)), nil
+	}
+
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("while reading %s from %s: %w", name, path, err)
+	}
+	return Secret(strings.TrimSpace(string(content))), nil
+}
@@ -2,7 +2,9 @@ package utils
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -28,3 +30,22 @@ func GetPort() (int, error) {
 
 	return port, nil
 }
+
+// WriteFileAtomic writes to a temp file in the same directory as path and then
+// renames it, so that readers never see a partially written file
+func WriteFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("while creating temp file for %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("while closing temp file for %s: %w", path, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
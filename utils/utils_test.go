@@ -1,6 +1,13 @@
 package utils
 
-import "testing"
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 func TestRemoveWhitespace(t *testing.T) {
 	raw := "\t\n  this 	\n contains    too \n\n much  space.   \n\t"
@@ -10,3 +17,42 @@ func TestRemoveWhitespace(t *testing.T) {
 		t.Fatalf("remove whitespace failed: expected [%s] got [%s]", expect, got)
 	}
 }
+
+func TestSecretIsRedacted(t *testing.T) {
+	secret := Secret("s3cr3t")
+	var logs strings.Builder
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	logger.Info("configured", "secret", secret)
+
+	if strings.Contains(logs.String(), "s3cr3t") {
+		t.Fatalf("secret was logged: %s", logs.String())
+	}
+	if formatted := fmt.Sprintf("%v %s %+v %#v", secret, secret, secret, secret); strings.Contains(formatted, "s3cr3t") {
+		t.Fatalf("secret was formatted: %s", formatted)
+	}
+}
+
+func TestLoadSecret(t *testing.T) {
+	t.Setenv("TEST_API_SECRET", "")
+	secret, err := LoadSecret("TEST_API_SECRET")
+	if err != nil || secret != "" {
+		t.Fatalf("expected no secret, got [%s] %v", string(secret), err)
+	}
+
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_API_SECRET_FILE", path)
+	secret, err = LoadSecret("TEST_API_SECRET")
+	if err != nil || secret != "from-file" {
+		t.Fatalf("expected the secret in the file, got [%s] %v", string(secret), err)
+	}
+
+	// the env var takes precedence
+	t.Setenv("TEST_API_SECRET", "from-env")
+	secret, err = LoadSecret("TEST_API_SECRET")
+	if err != nil || secret != "from-env" {
+		t.Fatalf("expected the secret in the env var, got [%s] %v", string(secret), err)
+	}
+}